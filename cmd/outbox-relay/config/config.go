@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 
@@ -16,7 +17,77 @@ type Config struct {
 	AdvisoryLock int64              `mapstructure:"advisory_lock"`
 	LogLevel     string             `mapstructure:"logging_level"`
 	LogFormat    string             `mapstructure:"logging_format"`
+	Storage      StorageConfig      `mapstructure:"storage"`
+	Publisher    PublisherConfig    `mapstructure:"publisher"`
+	Encryption   EncryptionConfig   `mapstructure:"encryption"`
 	Relay        outbox.RelayConfig `mapstructure:"relay"`
+	Cleanup      CleanupConfig      `mapstructure:"cleanup"`
+	// Partitions configures the partition maintenance, which runs when the outbox table is partitioned.
+	Partitions outbox.PartitionConfig `mapstructure:"partitions"`
+}
+
+// CleanupConfig holds the configuration of the retention cleanup.
+type CleanupConfig struct {
+	// Enabled runs the cleanup periodically inside the relay.
+	Enabled              bool `mapstructure:"enabled"`
+	outbox.CleanupConfig `mapstructure:",squash"`
+}
+
+// StorageConfig holds the configuration of the outbox storage.
+type StorageConfig struct {
+	Schema string `mapstructure:"schema"`
+	Table  string `mapstructure:"table"`
+	// ClaimRows enables row claiming, letting all relay instances process messages without leader election.
+	ClaimRows  bool          `mapstructure:"claim_rows"`
+	ClaimLease time.Duration `mapstructure:"claim_lease"`
+	// Archive moves messages to the archive table as soon as they are marked sent or dead.
+	Archive bool `mapstructure:"archive"`
+	// Partitioned creates the outbox table partitioned by day. It only takes effect when the table is created.
+	Partitioned bool `mapstructure:"partitioned"`
+	// NotifyChannel is the Postgres channel which inserts notify and relays listen on to wake up immediately.
+	NotifyChannel string `mapstructure:"notify_channel"`
+}
+
+// Options converts the storage configuration to outbox.SQLStorage options.
+func (c StorageConfig) Options() []outbox.SQLStorageOption {
+	opts := []outbox.SQLStorageOption{
+		outbox.WithSchema(c.Schema),
+		outbox.WithTableName(c.Table),
+		outbox.WithNotifyChannel(c.NotifyChannel),
+	}
+	if c.ClaimRows {
+		opts = append(opts, outbox.WithRowClaiming("", c.ClaimLease))
+	}
+	if c.Archive {
+		opts = append(opts, outbox.WithArchiving())
+	}
+	if c.Partitioned {
+		opts = append(opts, outbox.WithPartitioning())
+	}
+
+	return opts
+}
+
+// PublisherConfig holds the configuration of the NATS publisher.
+type PublisherConfig struct {
+	// Decompress publishes the original data of compressed messages instead of the compressed bytes.
+	Decompress bool `mapstructure:"decompress"`
+}
+
+// Options converts the publisher configuration to outbox.NatsPublisher options.
+func (c PublisherConfig) Options() []outbox.NatsPublisherOption {
+	var opts []outbox.NatsPublisherOption
+	if c.Decompress {
+		opts = append(opts, outbox.WithDecompression())
+	}
+
+	return opts
+}
+
+// EncryptionConfig holds the configuration of the payload encryption.
+type EncryptionConfig struct {
+	// KeyringFile is the path of the outbox.FileKeyring which decrypts encrypted messages before they are published.
+	KeyringFile string `mapstructure:"keyring_file"`
 }
 
 // NewConfig loads configuration from a file and then overrides it with environment variables.
@@ -42,12 +113,50 @@ func NewConfig(cfgPath string) (*Config, error) {
 	_ = v.BindEnv("logging_level")
 	_ = v.BindEnv("logging_format")
 	_ = v.BindEnv("advisory_lock")
+	_ = v.BindEnv("storage.schema")
+	_ = v.BindEnv("storage.table")
+	_ = v.BindEnv("storage.claim_rows")
+	_ = v.BindEnv("storage.claim_lease")
+	_ = v.BindEnv("storage.archive")
+	_ = v.BindEnv("storage.partitioned")
+	_ = v.BindEnv("storage.notify_channel")
+	_ = v.BindEnv("publisher.decompress")
+	_ = v.BindEnv("encryption.keyring_file")
 	_ = v.BindEnv("relay.poll_interval_ms")
 	_ = v.BindEnv("relay.batch_size")
+	_ = v.BindEnv("relay.backoff.base")
+	_ = v.BindEnv("relay.backoff.multiplier")
+	_ = v.BindEnv("relay.backoff.max")
+	_ = v.BindEnv("relay.backoff.jitter")
+	_ = v.BindEnv("relay.ordering_policy")
+	_ = v.BindEnv("cleanup.enabled")
+	_ = v.BindEnv("cleanup.interval")
+	_ = v.BindEnv("cleanup.batch_size")
+	_ = v.BindEnv("cleanup.sent_retention")
+	_ = v.BindEnv("cleanup.dead_retention")
+	_ = v.BindEnv("cleanup.mode")
+	_ = v.BindEnv("partitions.interval")
+	_ = v.BindEnv("partitions.premake")
+	_ = v.BindEnv("partitions.retention")
 
 	// Default values
+	v.SetDefault("storage.table", outbox.DefaultTableName)
+	v.SetDefault("storage.claim_lease", "30s")
 	v.SetDefault("relay.poll_interval", "1000ms") // 1 second
 	v.SetDefault("relay.batch_size", 100)
+	v.SetDefault("relay.backoff.base", "1s")
+	v.SetDefault("relay.backoff.multiplier", 2)
+	v.SetDefault("relay.backoff.max", "5m")
+	v.SetDefault("relay.backoff.jitter", 0.2)
+	v.SetDefault("relay.ordering_policy", outbox.OrderingPolicySkip)
+	v.SetDefault("cleanup.interval", "1m")
+	v.SetDefault("cleanup.batch_size", outbox.DefaultCleanupBatchSize)
+	v.SetDefault("cleanup.sent_retention", "168h")
+	v.SetDefault("cleanup.dead_retention", "720h")
+	v.SetDefault("cleanup.mode", outbox.CleanupModeDelete)
+	v.SetDefault("partitions.interval", "1h")
+	v.SetDefault("partitions.premake", 3)
+	v.SetDefault("partitions.retention", "720h")
 	v.SetDefault("logging_level", "info")
 	v.SetDefault("logging_format", "text")
 
@@ -57,5 +166,9 @@ func NewConfig(cfgPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if err := cfg.Relay.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid relay config: %w", err)
+	}
+
 	return &cfg, nil
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -16,10 +18,26 @@ import (
 	"github.com/mammadmodi/go-outbox/outbox"
 )
 
+const (
+	commandRun       = "run"
+	commandMigrate   = "migrate"
+	commandCleanup   = "cleanup"
+	commandBlocked   = "blocked"
+	commandRequeue   = "requeue"
+	commandSkip      = "skip"
+	commandReencrypt = "reencrypt"
+)
+
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// The first argument selects the command, running the relay by default.
+	command := commandRun
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
 	cfgPath := os.Getenv("CONFIG_PATH")
 	if cfgPath == "" {
 		cfgPath = "./config/relay.toml"
@@ -60,27 +78,133 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Connect to NATS
-	nc, err := nats.Connect(appCfg.NatsURL)
+	storage, err := outbox.NewSQLStorage(db, appCfg.Storage.Options()...)
 	if err != nil {
-		logger.Error("failed to connect to NATS", slog.Any("error", err))
+		logger.Error("failed to create outbox storage", slog.Any("error", err))
 
 		os.Exit(1)
 	}
+
+	switch command {
+	case commandRun:
+		if err = runRelay(ctx, cancel, appCfg, db, storage, logger); err != nil {
+			logger.Error("relay failed", slog.Any("error", err))
+
+			os.Exit(1)
+		}
+	case commandMigrate:
+		if err = migrate(ctx, storage, logger); err != nil {
+			logger.Error("migration failed", slog.Any("error", err))
+
+			os.Exit(1)
+		}
+	case commandCleanup:
+		if err = cleanup(ctx, storage, appCfg, logger); err != nil {
+			logger.Error("cleanup failed", slog.Any("error", err))
+
+			os.Exit(1)
+		}
+	case commandBlocked:
+		if err = listBlocked(ctx, storage, logger); err != nil {
+			logger.Error("failed to list blocked messages", slog.Any("error", err))
+
+			os.Exit(1)
+		}
+	case commandRequeue, commandSkip:
+		if len(os.Args) != 3 {
+			logger.Error("missing message ID", slog.String("command", command))
+
+			os.Exit(2)
+		}
+		if err = unblock(ctx, storage, os.Args[2], command == commandRequeue, logger); err != nil {
+			logger.Error("failed to unblock message", slog.Any("error", err))
+
+			os.Exit(1)
+		}
+	case commandReencrypt:
+		if err = reencrypt(ctx, storage, appCfg, logger); err != nil {
+			logger.Error("re-encryption failed", slog.Any("error", err))
+
+			os.Exit(1)
+		}
+	default:
+		logger.Error("unknown command", slog.String("command", command))
+
+		os.Exit(2)
+	}
+}
+
+// runRelay runs the relay until a shutdown signal is received.
+func runRelay(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	appCfg *config.Config,
+	db *sql.DB,
+	storage *outbox.SQLStorage,
+	logger *slog.Logger,
+) error {
+	if err := storage.Migrate(ctx); err != nil {
+		return fmt.Errorf("failed to migrate outbox schema: %w", err)
+	}
+
+	// Connect to NATS
+	nc, err := nats.Connect(appCfg.NatsURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
 	defer nc.Close()
 
 	// Initialize components
-	storage := outbox.NewSQLStorage(db)
-	if err = storage.InitOutboxTable(ctx); err != nil {
-		logger.Error("failed to initialize outbox table", slog.Any("error", err))
+	publisher := outbox.NewNatsPublisher(nc, logger, appCfg.Publisher.Options()...)
 
-		os.Exit(1)
+	// Row claiming coordinates the relay instances, so no leader is needed.
+	var elector outbox.LeaderElector
+	if !appCfg.Storage.ClaimRows {
+		elector = outbox.NewLeaseElector(db, appCfg.AdvisoryLock, logger)
 	}
 
-	publisher := outbox.NewNatsPublisher(nc, logger)
-	elector := outbox.NewLeaseElector(db, appCfg.AdvisoryLock, logger)
+	var relayOpts []outbox.RelayOption
+	if appCfg.Encryption.KeyringFile != "" {
+		keyring, err := outbox.NewFileKeyring(appCfg.Encryption.KeyringFile)
+		if err != nil {
+			return err
+		}
+		relayOpts = append(relayOpts, outbox.WithDecryption(keyring))
+	}
 
-	relay := outbox.NewRelay(storage, publisher, elector, appCfg.Relay, logger)
+	// Wake the relay up as soon as messages are inserted, the poll interval remains as a fallback
+	if appCfg.Storage.NotifyChannel != "" {
+		listener, err := outbox.NewNotifyListener(appCfg.DatabaseDSN, appCfg.Storage.NotifyChannel, logger)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = listener.Close()
+		}()
+		relayOpts = append(relayOpts, outbox.WithWakeup(listener.Wakeup()))
+	}
+
+	relay := outbox.NewRelay(storage, publisher, elector, appCfg.Relay, logger, relayOpts...)
+
+	// Start the retention cleanup in background, it stops once the context is canceled
+	if appCfg.Cleanup.Enabled {
+		cleaner := outbox.NewCleaner(storage, elector, appCfg.Cleanup.CleanupConfig, logger)
+		go func() {
+			if err := cleaner.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				logger.Error("cleaner stopped with error", slog.Any("error", err))
+			}
+		}()
+	}
+
+	// Maintain the daily partitions in background, it stops once the context is canceled
+	if appCfg.Storage.Partitioned {
+		manager := outbox.NewPartitionManager(storage, elector, appCfg.Partitions, logger)
+		go func() {
+			if err := manager.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				logger.Error("partition manager stopped with error", slog.Any("error", err))
+			}
+		}()
+	}
 
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -95,10 +219,128 @@ func main() {
 
 	// Start relay
 	if err = relay.Start(ctx); err != nil {
-		logger.Error("relay failed to start", slog.Any("error", err))
-
-		os.Exit(1)
+		return fmt.Errorf("relay failed to start: %w", err)
 	}
 
 	logger.Info("shutdown complete")
+
+	return nil
+}
+
+// migrate applies all pending outbox schema migrations and exits.
+func migrate(ctx context.Context, storage *outbox.SQLStorage, logger *slog.Logger) error {
+	if err := storage.Migrate(ctx); err != nil {
+		return err
+	}
+
+	version, err := storage.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("outbox schema is up to date", slog.Int("version", version))
+
+	return nil
+}
+
+// cleanup deletes (or archives) all sent and dead messages whose retention has passed and exits.
+func cleanup(ctx context.Context, storage *outbox.SQLStorage, appCfg *config.Config, logger *slog.Logger) error {
+	cleaner := outbox.NewCleaner(storage, nil, appCfg.Cleanup.CleanupConfig, logger)
+
+	removed, err := cleaner.RunOnce(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("outbox cleanup complete", slog.Int64("removed", removed))
+
+	return nil
+}
+
+// listBlocked logs the blocked messages, each of which holds back its aggregate, and exits.
+func listBlocked(ctx context.Context, storage *outbox.SQLStorage, logger *slog.Logger) error {
+	const limit = 1000
+
+	messages, err := storage.FetchBlockedMessages(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		attrs := []any{
+			slog.String("message_id", msg.ID.String()),
+			slog.String("aggregate_type", msg.AggregateType),
+			slog.String("aggregate_id", msg.AggregateID),
+			slog.Int("attempts", msg.Attempts),
+		}
+		if msg.LastError != nil {
+			attrs = append(attrs, slog.String("last_error", *msg.LastError))
+		}
+		logger.Info("blocked message", attrs...)
+	}
+
+	logger.Info("blocked messages listed", slog.Int("count", len(messages)))
+
+	return nil
+}
+
+// unblock either requeues a blocked message or skips it by marking it dead, and exits.
+func unblock(ctx context.Context, storage *outbox.SQLStorage, id string, requeue bool, logger *slog.Logger) error {
+	if requeue {
+		if err := storage.RequeueMessage(ctx, id); err != nil {
+			return err
+		}
+		logger.Info("message requeued", slog.String("message_id", id))
+
+		return nil
+	}
+
+	if err := storage.SkipBlockedMessage(ctx, id, "skipped by operator"); err != nil {
+		return err
+	}
+	logger.Info("message skipped", slog.String("message_id", id))
+
+	return nil
+}
+
+// reencrypt wraps the data keys of all pending and blocked messages with the current key of the keyring,
+// so older keys can be removed from it, and exits.
+func reencrypt(ctx context.Context, storage *outbox.SQLStorage, appCfg *config.Config, logger *slog.Logger) error {
+	const batchSize = 500
+
+	if appCfg.Encryption.KeyringFile == "" {
+		return errors.New("no keyring file configured")
+	}
+	keyring, err := outbox.NewFileKeyring(appCfg.Encryption.KeyringFile)
+	if err != nil {
+		return err
+	}
+
+	// A batch may come back short because rows locked by a relay are skipped, so only an empty batch
+	// ends the loop.
+	total := 0
+	for {
+		updated, err := storage.ReencryptMessages(ctx, keyring, batchSize)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			break
+		}
+		total += updated
+	}
+
+	logger.Info("outbox messages re-encrypted",
+		slog.String("key_id", keyring.CurrentKeyID()), slog.Int("count", total))
+
+	// Messages locked until the end are still encrypted with an older key, which must not be removed yet.
+	remaining, err := storage.CountMessagesToReencrypt(ctx, keyring)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%d messages are still encrypted with older keys, run reencrypt again", remaining)
+	}
+
+	return nil
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
 	"github.com/nats-io/nats.go"
+
+	"github.com/mammadmodi/go-outbox/outbox"
 )
 
 func main() {
@@ -18,6 +21,16 @@ func main() {
 		topic = "users"
 	}
 
+	// Claim-checked payloads are read from the blob directory shared with the producers, if any.
+	var store outbox.BlobStore
+	if blobDir := os.Getenv("BLOB_DIR"); blobDir != "" {
+		fileStore, err := outbox.NewFileBlobStore(blobDir)
+		if err != nil {
+			log.Fatalf("Failed to open blob store: %v", err)
+		}
+		store = fileStore
+	}
+
 	nc, err := nats.Connect(natsURL)
 	if err != nil {
 		log.Fatalf("Failed to connect to NATS: %v", err)
@@ -29,7 +42,12 @@ func main() {
 	}()
 
 	_, err = nc.Subscribe(topic, func(m *nats.Msg) {
-		log.Printf("Received a message on topic [%s]: %s\n", m.Subject, string(m.Data))
+		data, err := outbox.ResolveNatsPayload(context.Background(), store, m)
+		if err != nil {
+			log.Printf("Failed to resolve a message on topic [%s]: %v\n", m.Subject, err)
+			return
+		}
+		log.Printf("Received a message on topic [%s]: %s\n", m.Subject, string(data))
 	})
 	if err != nil {
 		log.Fatalf("Failed to subscribe to topic: %v", err)
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/mammadmodi/go-outbox/outbox"
@@ -16,6 +17,7 @@ import (
 type App struct {
 	db            *sql.DB
 	outboxStorage *outbox.SQLStorage
+	emitter       *outbox.Emitter
 	server        *http.Server
 	port          string
 	logger        *slog.Logger
@@ -25,6 +27,7 @@ type App struct {
 func NewApp(storage *outbox.SQLStorage, db *sql.DB, port string, logger *slog.Logger) *App {
 	return &App{
 		outboxStorage: storage,
+		emitter:       outbox.NewEmitter(storage),
 		db:            db,
 		port:          port,
 		logger:        logger,
@@ -33,7 +36,7 @@ func NewApp(storage *outbox.SQLStorage, db *sql.DB, port string, logger *slog.Lo
 
 // Init initializes the HTTP server and prepares the database.
 func (a *App) Init(ctx context.Context) error {
-	if err := a.outboxStorage.InitOutboxTable(ctx); err != nil {
+	if err := a.outboxStorage.Migrate(ctx); err != nil {
 		return err
 	}
 
@@ -97,15 +100,10 @@ func (a *App) createUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	event := outbox.StorageRecord{
-		EventType:     "UserCreated",
-		AggregateType: "User",
-		AggregateID:   fmt.Sprintf("%d", userID),
-		Data:          []byte(fmt.Sprintf(`{"id":%d,"name":"%s","email":"%s"}`, userID, req.Name, req.Email)),
-		Topic:         "users",
-	}
+	event := UserCreated{ID: userID, Name: req.Name, Email: req.Email}
 
-	if err = a.outboxStorage.InsertMessage(ctx, tx, event); err != nil {
+	messageID, err := outbox.Emit(ctx, a.emitter, tx, event)
+	if err != nil {
 		http.Error(w, "failed to insert outbox message", http.StatusInternalServerError)
 		return
 	}
@@ -115,6 +113,9 @@ func (a *App) createUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.logger.With(slog.Int64("user_id", userID), slog.String("message_id", messageID.String())).
+		Info("App: user created")
+
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": userID})
 }
@@ -130,6 +131,11 @@ func (a *App) verifyUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID := parts[2]
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
 
 	tx, err := a.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -167,15 +173,10 @@ func (a *App) verifyUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert verification event
-	event := outbox.StorageRecord{
-		EventType:     "UserVerified",
-		AggregateType: "User",
-		AggregateID:   userID,
-		Data:          []byte(fmt.Sprintf(`{"id":%s,"verified":true}`, userID)),
-		Topic:         "users",
-	}
+	event := UserVerified{ID: id, Verified: true}
 
-	if err = a.outboxStorage.InsertMessage(ctx, tx, event); err != nil {
+	messageID, err := outbox.Emit(ctx, a.emitter, tx, event)
+	if err != nil {
 		http.Error(w, "failed to insert outbox message", http.StatusInternalServerError)
 		return
 	}
@@ -185,6 +186,9 @@ func (a *App) verifyUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.logger.With(slog.String("user_id", userID), slog.String("message_id", messageID.String())).
+		Info("App: user verified")
+
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(map[string]string{"status": "verified"})
 }
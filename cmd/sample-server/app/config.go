@@ -3,6 +3,7 @@ package app
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 
@@ -17,9 +18,45 @@ type Config struct {
 	AdvisoryLock int64              `mapstructure:"advisory_lock"`
 	LogLevel     string             `mapstructure:"logging_level"`
 	LogFormat    string             `mapstructure:"logging_format"`
+	Storage      StorageConfig      `mapstructure:"storage"`
 	Relay        outbox.RelayConfig `mapstructure:"relay"`
 }
 
+// StorageConfig holds the configuration of the outbox storage.
+type StorageConfig struct {
+	Schema string `mapstructure:"schema"`
+	Table  string `mapstructure:"table"`
+	// ClaimRows enables row claiming, letting all relay instances process messages without leader election.
+	ClaimRows  bool          `mapstructure:"claim_rows"`
+	ClaimLease time.Duration `mapstructure:"claim_lease"`
+	// Archive moves messages to the archive table as soon as they are marked sent or dead.
+	Archive bool `mapstructure:"archive"`
+	// Partitioned creates the outbox table partitioned by day. It only takes effect when the table is created.
+	Partitioned bool `mapstructure:"partitioned"`
+	// NotifyChannel is the Postgres channel which inserts notify and relays listen on to wake up immediately.
+	NotifyChannel string `mapstructure:"notify_channel"`
+}
+
+// Options converts the storage configuration to outbox.SQLStorage options.
+func (c StorageConfig) Options() []outbox.SQLStorageOption {
+	opts := []outbox.SQLStorageOption{
+		outbox.WithSchema(c.Schema),
+		outbox.WithTableName(c.Table),
+		outbox.WithNotifyChannel(c.NotifyChannel),
+	}
+	if c.ClaimRows {
+		opts = append(opts, outbox.WithRowClaiming("", c.ClaimLease))
+	}
+	if c.Archive {
+		opts = append(opts, outbox.WithArchiving())
+	}
+	if c.Partitioned {
+		opts = append(opts, outbox.WithPartitioning())
+	}
+
+	return opts
+}
+
 // NewConfig loads configuration from a file and then overrides it with environment variables.
 func NewConfig(cfgPath string) (*Config, error) {
 	v := viper.New()
@@ -44,13 +81,32 @@ func NewConfig(cfgPath string) (*Config, error) {
 	_ = v.BindEnv("logging_level")
 	_ = v.BindEnv("logging_format")
 	_ = v.BindEnv("advisory_lock")
+	_ = v.BindEnv("storage.schema")
+	_ = v.BindEnv("storage.table")
+	_ = v.BindEnv("storage.claim_rows")
+	_ = v.BindEnv("storage.claim_lease")
+	_ = v.BindEnv("storage.archive")
+	_ = v.BindEnv("storage.partitioned")
+	_ = v.BindEnv("storage.notify_channel")
 	_ = v.BindEnv("relay.poll_interval_ms")
 	_ = v.BindEnv("relay.batch_size")
+	_ = v.BindEnv("relay.backoff.base")
+	_ = v.BindEnv("relay.backoff.multiplier")
+	_ = v.BindEnv("relay.backoff.max")
+	_ = v.BindEnv("relay.backoff.jitter")
+	_ = v.BindEnv("relay.ordering_policy")
 
 	// Default values
 	v.SetDefault("server_port", ":8080")
+	v.SetDefault("storage.table", outbox.DefaultTableName)
+	v.SetDefault("storage.claim_lease", "30s")
 	v.SetDefault("relay.poll_interval", "1000ms") // 1 second
 	v.SetDefault("relay.batch_size", 100)
+	v.SetDefault("relay.backoff.base", "1s")
+	v.SetDefault("relay.backoff.multiplier", 2)
+	v.SetDefault("relay.backoff.max", "5m")
+	v.SetDefault("relay.backoff.jitter", 0.2)
+	v.SetDefault("relay.ordering_policy", outbox.OrderingPolicySkip)
 	v.SetDefault("logging_level", "info")
 	v.SetDefault("logging_format", "text")
 
@@ -60,5 +116,9 @@ func NewConfig(cfgPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if err := cfg.Relay.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid relay config: %w", err)
+	}
+
 	return &cfg, nil
 }
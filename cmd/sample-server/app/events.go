@@ -0,0 +1,26 @@
+package app
+
+import "strconv"
+
+// UserCreated is emitted when a user is created.
+type UserCreated struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (UserCreated) EventType() string     { return "UserCreated" }
+func (UserCreated) AggregateType() string { return "User" }
+func (e UserCreated) AggregateID() string { return strconv.FormatInt(e.ID, 10) }
+func (UserCreated) Topic() string         { return "users" }
+
+// UserVerified is emitted when a user is verified.
+type UserVerified struct {
+	ID       int64 `json:"id"`
+	Verified bool  `json:"verified"`
+}
+
+func (UserVerified) EventType() string     { return "UserVerified" }
+func (UserVerified) AggregateType() string { return "User" }
+func (e UserVerified) AggregateID() string { return strconv.FormatInt(e.ID, 10) }
+func (UserVerified) Topic() string         { return "users" }
@@ -66,15 +66,39 @@ func main() {
 	defer nc.Close()
 
 	// Initialize components
-	storage := outbox.NewSQLStorage(db)
-	if err = storage.InitOutboxTable(ctx); err != nil {
-		logger.Error("failed to initialize outbox table", slog.Any("error", err))
+	storage, err := outbox.NewSQLStorage(db, appCfg.Storage.Options()...)
+	if err != nil {
+		logger.Error("failed to create outbox storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	if err = storage.Migrate(ctx); err != nil {
+		logger.Error("failed to migrate outbox schema", slog.Any("error", err))
 		os.Exit(1)
 	}
 
 	publisher := outbox.NewNatsPublisher(nc, logger)
-	elector := outbox.NewLeaseElector(db, appCfg.AdvisoryLock, logger)
-	relay := outbox.NewRelay(storage, publisher, elector, appCfg.Relay, logger)
+
+	// Row claiming coordinates the relay instances, so no leader is needed.
+	var elector outbox.LeaderElector
+	if !appCfg.Storage.ClaimRows {
+		elector = outbox.NewLeaseElector(db, appCfg.AdvisoryLock, logger)
+	}
+
+	// Wake the relay up as soon as messages are inserted, the poll interval remains as a fallback
+	var relayOpts []outbox.RelayOption
+	if appCfg.Storage.NotifyChannel != "" {
+		listener, err := outbox.NewNotifyListener(appCfg.DatabaseDSN, appCfg.Storage.NotifyChannel, logger)
+		if err != nil {
+			logger.Error("failed to listen for outbox notifications", slog.Any("error", err))
+			os.Exit(1)
+		}
+		defer func() {
+			_ = listener.Close()
+		}()
+		relayOpts = append(relayOpts, outbox.WithWakeup(listener.Wakeup()))
+	}
+	relay := outbox.NewRelay(storage, publisher, elector, appCfg.Relay, logger, relayOpts...)
 
 	sampleServer := app.NewApp(storage, db, appCfg.ServerPort, logger)
 	if err = sampleServer.Init(ctx); err != nil {
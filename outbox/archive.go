@@ -0,0 +1,149 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// archiveCopyColumns lists the columns copied verbatim when a message is moved to the archive table.
+// The status, sent_at and last_error columns are set by the moving statement.
+const archiveCopyColumns = "id, event_type, aggregate_type, aggregate_id, data, created_at, attempts, topic, " +
+	"dedup_key, headers, seq, available_at, next_attempt_at, priority, content_encoding, key_id, payload_ref"
+
+// archiveConflictClause replaces an archived message whose ID was stored again, e.g. with a
+// deduplication key reused after its message was archived. Failing instead would leave the new
+// message pending, publishing it on every poll. It lists every column of the archive table.
+//
+// A partitioned outbox table may hold several messages with one ID, of which the moving statements
+// archive the newest (DISTINCT ON), as a row cannot be updated twice by one statement.
+const archiveConflictClause = `ON CONFLICT (id) DO UPDATE SET
+			event_type = EXCLUDED.event_type, aggregate_type = EXCLUDED.aggregate_type,
+			aggregate_id = EXCLUDED.aggregate_id, data = EXCLUDED.data, created_at = EXCLUDED.created_at,
+			attempts = EXCLUDED.attempts, topic = EXCLUDED.topic, dedup_key = EXCLUDED.dedup_key,
+			headers = EXCLUDED.headers, seq = EXCLUDED.seq, available_at = EXCLUDED.available_at,
+			next_attempt_at = EXCLUDED.next_attempt_at, priority = EXCLUDED.priority,
+			content_encoding = EXCLUDED.content_encoding, key_id = EXCLUDED.key_id,
+			payload_ref = EXCLUDED.payload_ref, last_error = EXCLUDED.last_error,
+			last_error_at = EXCLUDED.last_error_at, status = EXCLUDED.status, sent_at = EXCLUDED.sent_at,
+			archived_at = now()`
+
+const (
+	// archiveSentQuery marks a message as sent by moving it to the archive table.
+	archiveSentQuery = `
+		WITH moved AS (
+			DELETE FROM {table}
+			WHERE id = $2
+			RETURNING ` + archiveCopyColumns + `, last_error, last_error_at
+		)
+		INSERT INTO {archive_table} (` + archiveCopyColumns + `, last_error, last_error_at, status, sent_at)
+		SELECT DISTINCT ON (id) ` + archiveCopyColumns + `, last_error, last_error_at, $1, NOW()
+		FROM moved
+		ORDER BY id, created_at DESC
+		` + archiveConflictClause + `
+	`
+
+	// archiveDeadQuery marks a message as dead by moving it to the archive table.
+	archiveDeadQuery = `
+		WITH moved AS (
+			DELETE FROM {table}
+			WHERE id = $2
+			RETURNING ` + archiveCopyColumns + `, last_error
+		)
+		INSERT INTO {archive_table} (` + archiveCopyColumns + `, last_error, last_error_at, status, sent_at)
+		SELECT DISTINCT ON (id) ` + archiveCopyColumns + `,
+			CASE WHEN last_error IS NULL THEN $3 ELSE $3 || ': ' || last_error END, NOW(), $1, NOW()
+		FROM moved
+		ORDER BY id, created_at DESC
+		` + archiveConflictClause + `
+	`
+
+	// archiveSkipBlockedQuery marks a blocked message as dead by moving it to the archive table.
+	archiveSkipBlockedQuery = `
+		WITH moved AS (
+			DELETE FROM {table}
+			WHERE id = $2 AND status = $4
+			RETURNING ` + archiveCopyColumns + `, last_error
+		)
+		INSERT INTO {archive_table} (` + archiveCopyColumns + `, last_error, last_error_at, status, sent_at)
+		SELECT DISTINCT ON (id) ` + archiveCopyColumns + `,
+			CASE WHEN last_error IS NULL THEN $3 ELSE $3 || ': ' || last_error END, NOW(), $1, NOW()
+		FROM moved
+		ORDER BY id, created_at DESC
+		` + archiveConflictClause + `
+	`
+
+	// archiveAggregateDeadQuery marks a message and the later pending messages of its aggregate as dead
+	// by moving them to the archive table.
+	archiveAggregateDeadQuery = `
+		WITH moved AS (
+			DELETE FROM {table}
+			WHERE status = $5
+				AND (aggregate_type, aggregate_id) = (SELECT aggregate_type, aggregate_id FROM {table} WHERE id = $2)
+			RETURNING ` + archiveCopyColumns + `, last_error
+		)
+		INSERT INTO {archive_table} (` + archiveCopyColumns + `, last_error, last_error_at, status, sent_at)
+		SELECT DISTINCT ON (id) ` + archiveCopyColumns + `,
+			CASE
+				WHEN id = $2 THEN CASE WHEN last_error IS NULL THEN $3 ELSE $3 || ': ' || last_error END
+				ELSE $4
+			END, NOW(), $1, NOW()
+		FROM moved
+		ORDER BY id, created_at DESC
+		` + archiveConflictClause + `
+	`
+)
+
+// ArchiveMessages moves up to limit messages of the given status which were sent (or marked dead)
+// before olderThan to the archive table, and returns how many were moved. Rows locked by others
+// are skipped.
+//
+// The count is taken from the deleted rows rather than the archived ones, which are fewer when a
+// partitioned outbox table holds several messages with one ID, so the Cleaner does not mistake a
+// full batch for the last one.
+func (s *SQLStorage) ArchiveMessages(ctx context.Context, status string, olderThan time.Time, limit int) (int64, error) {
+	const query = `
+		WITH moved AS (
+			DELETE FROM {table}
+			WHERE id IN (
+				SELECT id FROM {table}
+				WHERE status = $1 AND sent_at < $2
+				LIMIT $3
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING ` + archiveCopyColumns + `, status, sent_at, last_error, last_error_at
+		), archived AS (
+			INSERT INTO {archive_table} (` + archiveCopyColumns + `, status, sent_at, last_error, last_error_at)
+			SELECT DISTINCT ON (id) ` + archiveCopyColumns + `, status, sent_at, last_error, last_error_at
+			FROM moved
+			ORDER BY id, created_at DESC
+			` + archiveConflictClause + `
+		)
+		SELECT COUNT(*) FROM moved
+	`
+	var moved int64
+	if err := s.db.QueryRowContext(ctx, s.sql(query), status, olderThan, limit).Scan(&moved); err != nil {
+		return 0, fmt.Errorf("failed to archive messages: %w", err)
+	}
+	return moved, nil
+}
+
+// FetchArchivedMessages retrieves up to limit archived messages of an aggregate in their original order.
+func (s *SQLStorage) FetchArchivedMessages(
+	ctx context.Context, aggregateType, aggregateID string, limit int,
+) ([]*StorageRecord, error) {
+	const query = `
+		SELECT ` + recordColumns + `
+		FROM {archive_table}
+		WHERE aggregate_type = $1 AND aggregate_id = $2
+		ORDER BY created_at ASC, seq ASC
+		LIMIT $3
+	`
+
+	rows, err := s.db.QueryContext(ctx, s.sql(query), aggregateType, aggregateID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archived messages: %w", err)
+	}
+
+	return scanRecords(rows)
+}
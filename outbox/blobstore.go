@@ -0,0 +1,93 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore stores the payloads of oversized messages outside of the outbox table, see ClaimCheck.
+// The outbox never deletes stored payloads.
+type BlobStore interface {
+	// Put stores data under the given key and returns the reference which retrieves it.
+	Put(ctx context.Context, key string, data []byte) (string, error)
+	// Get retrieves the data stored under the given reference.
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// FileBlobStore is a BlobStore which keeps every payload in a file of a local directory, e.g. a
+// volume shared by the producers and consumers. The reference of a payload is its file name.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore creates a FileBlobStore in dir, creating the directory if needed.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	return &FileBlobStore{dir: dir}, nil
+}
+
+// Put writes data to the file named key. The file is written atomically, so readers never see a
+// partial payload.
+func (s *FileBlobStore) Put(_ context.Context, key string, data []byte) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return "", fmt.Errorf("failed to write blob file: %w", err)
+	}
+	// The message referencing the payload is committed after Put returns, so the payload must be on
+	// disk by then to survive a crash.
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return "", fmt.Errorf("failed to sync blob file: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write blob file: %w", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write blob file: %w", err)
+	}
+
+	return key, nil
+}
+
+// Get reads the file named ref.
+func (s *FileBlobStore) Get(_ context.Context, ref string) ([]byte, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob file: %w", err)
+	}
+
+	return data, nil
+}
+
+// path returns the path of the file named name, which must not leave the directory of the store.
+func (s *FileBlobStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid blob name %q", name)
+	}
+
+	return filepath.Join(s.dir, name), nil
+}
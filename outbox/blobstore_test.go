@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+
+	ref, err := store.Put(ctx, "0b9c3f6e-8d2a-4c1e-9f47-2a6d5e8b1c03", []byte("payload"))
+	if err != nil {
+		t.Fatalf("failed to put blob: %v", err)
+	}
+
+	data, err := store.Get(ctx, ref)
+	if err != nil || string(data) != "payload" {
+		t.Errorf("unexpected blob: got %q, %v", data, err)
+	}
+
+	for _, name := range []string{"", "../escape", "sub/blob", ".tmp-1"} {
+		if _, err = store.Put(ctx, name, nil); err == nil {
+			t.Errorf("expected an error for blob name %q", name)
+		}
+	}
+}
+
+func TestClaimCheck(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+
+	large := bytes.Repeat([]byte("x"), 100)
+	storage := NewMemoryStorage()
+	_, err = storage.InsertMessages(ctx, []StorageRecord{
+		{EventType: "FileUploaded", AggregateType: "File", AggregateID: "1", Data: large, Topic: "files"},
+		{EventType: "FileUploaded", AggregateType: "File", AggregateID: "2", Data: []byte("small"), Topic: "files"},
+	}, ClaimCheck(store, 50))
+	if err != nil {
+		t.Fatalf("failed to insert messages: %v", err)
+	}
+
+	messages := storage.Messages()
+	if len(messages[0].Data) != 0 || !strings.HasPrefix(messages[0].PayloadRef, messages[0].ID.String()+".") {
+		t.Errorf("large message was not claim-checked: got %+v", messages[0])
+	}
+	if string(messages[1].Data) != "small" || messages[1].PayloadRef != "" {
+		t.Errorf("small message was claim-checked: got %+v", messages[1])
+	}
+
+	// The consumer resolves the payload from the published message.
+	natsMsg, err := NewNatsPublisher(nil, nil).natsMsg(&messages[0])
+	if err != nil {
+		t.Fatalf("failed to build message: %v", err)
+	}
+	data, err := ResolveNatsPayload(ctx, store, natsMsg)
+	if err != nil || !bytes.Equal(data, large) {
+		t.Errorf("unexpected resolved payload: got %q, %v", data, err)
+	}
+
+	if _, err = ResolveNatsPayload(ctx, nil, natsMsg); err == nil {
+		t.Error("expected an error without blob store")
+	}
+	data, err = ResolveNatsPayload(ctx, nil, &nats.Msg{Data: []byte("small")})
+	if err != nil || string(data) != "small" {
+		t.Errorf("unexpected payload: got %q, %v", data, err)
+	}
+}
+
+func TestClaimCheck_WithEncryption(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+	keyring := newTestKeyring(t, "2024-01", newTestKeys(t, "2024-01"))
+
+	storage := NewMemoryStorage()
+	_, err = storage.InsertMessages(context.Background(), []StorageRecord{
+		{EventType: "FileUploaded", AggregateType: "File", AggregateID: "1", Data: bytes.Repeat([]byte("x"), 100),
+			Topic: "files"},
+	}, ClaimCheck(store, 50), Encrypt(keyring))
+	if err == nil {
+		t.Fatal("expected an error when combining claim-check and encryption")
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("unexpected blobs: got %d, want 0", len(entries))
+	}
+	if messages := storage.Messages(); len(messages) != 0 {
+		t.Errorf("unexpected messages: got %d, want 0", len(messages))
+	}
+}
+
+func TestClaimCheck_DuplicateMessage(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+
+	first := bytes.Repeat([]byte("a"), 100)
+	msg := StorageRecord{EventType: "FileUploaded", AggregateType: "File", AggregateID: "1", Data: first,
+		Topic: "files", DeduplicationKey: "file-1-uploaded"}
+	storage := NewMemoryStorage()
+	if _, err = storage.InsertMessage(ctx, msg, ClaimCheck(store, 50)); err != nil {
+		t.Fatalf("failed to insert message: %v", err)
+	}
+
+	// The retry carries other data, which must not replace the payload of the stored message.
+	msg.Data = bytes.Repeat([]byte("b"), 100)
+	if _, err = storage.InsertMessage(ctx, msg, ClaimCheck(store, 50), IgnoreDuplicates()); !errors.Is(err, ErrMessageExists) {
+		t.Fatalf("unexpected error: got %v, want ErrMessageExists", err)
+	}
+
+	messages := storage.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("unexpected messages: got %d, want 1", len(messages))
+	}
+	data, err := store.Get(ctx, messages[0].PayloadRef)
+	if err != nil || !bytes.Equal(data, first) {
+		t.Errorf("unexpected payload of the stored message: got %q, %v", data, err)
+	}
+}
+
+func TestClaimCheck_InvalidMessage(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+	validator, err := NewSchemaValidator(writeTestSchemas(t, map[string]string{"UserCreated": userCreatedSchema}))
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	padding := strings.Repeat("x", 100)
+	storage := NewMemoryStorage()
+	_, err = storage.InsertMessages(context.Background(), []StorageRecord{
+		{EventType: "UserCreated", Data: []byte(`{"id":1,"email":"john@example.com","bio":"` + padding + `"}`),
+			Topic: "users"},
+		{EventType: "UserCreated", Data: []byte(`{"id":2,"bio":"` + padding + `"}`), Topic: "users"},
+	}, Validate(validator), ClaimCheck(store, 50))
+	if !errors.Is(err, ErrInvalidEvent) {
+		t.Fatalf("unexpected error: got %v, want ErrInvalidEvent", err)
+	}
+
+	// The valid message is not offloaded either, as the batch fails before any payload is stored.
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("unexpected blobs: got %d, want 0", len(entries))
+	}
+}
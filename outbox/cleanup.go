@@ -0,0 +1,165 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DefaultCleanupBatchSize is used when CleanupConfig.BatchSize is not set.
+const DefaultCleanupBatchSize = 1000
+
+const (
+	// CleanupModeDelete deletes expired messages.
+	CleanupModeDelete = "delete"
+	// CleanupModeArchive moves expired messages to the archive table.
+	CleanupModeArchive = "archive"
+)
+
+type (
+	// Cleaner periodically deletes (or archives) sent and dead messages once their retention window has passed.
+	Cleaner struct {
+		logger  *slog.Logger
+		storage CleanupStorage
+		leader  LeaderElector
+		cfg     CleanupConfig
+		done    chan struct{}
+	}
+
+	// CleanupConfig holds the configuration for the cleanup of delivered and dead messages.
+	CleanupConfig struct {
+		// Interval is the interval between cleanup runs.
+		Interval time.Duration `mapstructure:"interval"`
+		// BatchSize is the maximum number of messages deleted by a single statement, which bounds
+		// how long rows stay locked.
+		BatchSize int `mapstructure:"batch_size"`
+		// SentRetention is how long sent messages are kept. Zero keeps them forever.
+		SentRetention time.Duration `mapstructure:"sent_retention"`
+		// DeadRetention is how long dead messages are kept. Zero keeps them forever.
+		DeadRetention time.Duration `mapstructure:"dead_retention"`
+		// Mode is either CleanupModeDelete (the default) or CleanupModeArchive.
+		Mode string `mapstructure:"mode"`
+	}
+
+	// CleanupStorage abstracts removing expired messages.
+	CleanupStorage interface {
+		// DeleteMessages deletes up to limit messages of the given status which were sent (or marked
+		// dead) before olderThan, and returns how many were deleted.
+		DeleteMessages(ctx context.Context, status string, olderThan time.Time, limit int) (int64, error)
+		// ArchiveMessages is like DeleteMessages, but moves the messages to the archive.
+		ArchiveMessages(ctx context.Context, status string, olderThan time.Time, limit int) (int64, error)
+	}
+)
+
+// NewCleaner creates a new Cleaner. The leader may be nil, in which case every instance cleans up.
+func NewCleaner(storage CleanupStorage, leader LeaderElector, cfg CleanupConfig, logger *slog.Logger) *Cleaner {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultCleanupBatchSize
+	}
+
+	return &Cleaner{
+		storage: storage,
+		leader:  leader,
+		cfg:     cfg,
+		logger:  logger,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start starts the cleanup loop.
+func (c *Cleaner) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Info("Cleaner: context canceled, stopping")
+			return ctx.Err()
+		case <-c.done:
+			c.logger.Info("Cleaner: done signal received, stopping")
+			return nil
+		case <-ticker.C:
+			c.tick(ctx)
+		}
+	}
+}
+
+func (c *Cleaner) tick(ctx context.Context) {
+	if c.leader != nil {
+		isLeader, err := c.leader.IsLeader(ctx)
+		if err != nil {
+			c.logger.Error("Cleaner: failed to check leadership", slog.Any("error", err))
+
+			return
+		}
+
+		if !isLeader {
+			c.logger.Debug("Cleaner: not leader, skipping tick")
+
+			return
+		}
+	}
+
+	if _, err := c.RunOnce(ctx); err != nil {
+		c.logger.Error("Cleaner: failed to clean up messages", slog.Any("error", err))
+	}
+}
+
+// RunOnce deletes (or archives) all messages whose retention has passed, batch by batch, and returns
+// how many were removed from the outbox.
+func (c *Cleaner) RunOnce(ctx context.Context) (int64, error) {
+	remove := c.storage.DeleteMessages
+	switch c.cfg.Mode {
+	case "", CleanupModeDelete:
+	case CleanupModeArchive:
+		remove = c.storage.ArchiveMessages
+	default:
+		return 0, fmt.Errorf("invalid cleanup mode %q", c.cfg.Mode)
+	}
+
+	retentions := []struct {
+		status    string
+		retention time.Duration
+	}{
+		{status: RecordStatusSent, retention: c.cfg.SentRetention},
+		{status: RecordStatusDead, retention: c.cfg.DeadRetention},
+	}
+
+	var total int64
+	for _, r := range retentions {
+		if r.retention <= 0 {
+			continue
+		}
+
+		olderThan := time.Now().Add(-r.retention)
+		for {
+			if err := ctx.Err(); err != nil {
+				return total, err
+			}
+
+			removed, err := remove(ctx, r.status, olderThan, c.cfg.BatchSize)
+			if err != nil {
+				return total, fmt.Errorf("failed to clean up %s messages: %w", r.status, err)
+			}
+			total += removed
+
+			if removed < int64(c.cfg.BatchSize) {
+				break
+			}
+		}
+	}
+
+	if total > 0 {
+		c.logger.With(slog.Int64("removed", total), slog.String("mode", c.cfg.Mode)).
+			Info("Cleaner: removed expired messages")
+	}
+
+	return total, nil
+}
+
+// ShutDown gracefully stops the cleaner.
+func (c *Cleaner) ShutDown() {
+	close(c.done)
+}
@@ -0,0 +1,117 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mammadmodi/go-outbox/outbox"
+)
+
+// MockCleanupStorage mocks CleanupStorage interface
+type MockCleanupStorage struct {
+	mock.Mock
+}
+
+func (m *MockCleanupStorage) DeleteMessages(
+	ctx context.Context, status string, olderThan time.Time, limit int,
+) (int64, error) {
+	args := m.Called(ctx, status, olderThan, limit)
+
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockCleanupStorage) ArchiveMessages(
+	ctx context.Context, status string, olderThan time.Time, limit int,
+) (int64, error) {
+	args := m.Called(ctx, status, olderThan, limit)
+
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func TestCleaner_RunOnce(t *testing.T) {
+	olderThan := func(retention time.Duration) interface{} {
+		return mock.MatchedBy(func(t time.Time) bool {
+			d := time.Since(t) - retention
+
+			return d >= 0 && d < time.Second
+		})
+	}
+
+	tests := []struct {
+		name          string
+		cfg           outbox.CleanupConfig
+		mockSetup     func(storage *MockCleanupStorage)
+		expected      int64
+		expectedError bool
+	}{
+		{
+			name: "#1 Deletes in batches until the backlog is cleared",
+			cfg:  outbox.CleanupConfig{BatchSize: 2, SentRetention: time.Hour, DeadRetention: 24 * time.Hour},
+			mockSetup: func(storage *MockCleanupStorage) {
+				storage.On("DeleteMessages", mock.Anything, outbox.RecordStatusSent, olderThan(time.Hour), 2).
+					Return(int64(2), nil).Twice()
+				storage.On("DeleteMessages", mock.Anything, outbox.RecordStatusSent, olderThan(time.Hour), 2).
+					Return(int64(1), nil).Once()
+				storage.On("DeleteMessages", mock.Anything, outbox.RecordStatusDead, olderThan(24*time.Hour), 2).
+					Return(int64(0), nil).Once()
+			},
+			expected: 5,
+		},
+		{
+			name: "#2 Zero retention keeps messages",
+			cfg:  outbox.CleanupConfig{BatchSize: 10, DeadRetention: time.Hour},
+			mockSetup: func(storage *MockCleanupStorage) {
+				storage.On("DeleteMessages", mock.Anything, outbox.RecordStatusDead, olderThan(time.Hour), 10).
+					Return(int64(3), nil).Once()
+			},
+			expected: 3,
+		},
+		{
+			name: "#3 Archive mode moves messages to the archive",
+			cfg:  outbox.CleanupConfig{BatchSize: 10, SentRetention: time.Hour, Mode: outbox.CleanupModeArchive},
+			mockSetup: func(storage *MockCleanupStorage) {
+				storage.On("ArchiveMessages", mock.Anything, outbox.RecordStatusSent, olderThan(time.Hour), 10).
+					Return(int64(4), nil).Once()
+			},
+			expected: 4,
+		},
+		{
+			name:          "#4 Invalid mode",
+			cfg:           outbox.CleanupConfig{BatchSize: 10, SentRetention: time.Hour, Mode: "truncate"},
+			mockSetup:     func(storage *MockCleanupStorage) {},
+			expectedError: true,
+		},
+		{
+			name: "#5 Storage error stops the run",
+			cfg:  outbox.CleanupConfig{BatchSize: 10, SentRetention: time.Hour, DeadRetention: time.Hour},
+			mockSetup: func(storage *MockCleanupStorage) {
+				storage.On("DeleteMessages", mock.Anything, outbox.RecordStatusSent, olderThan(time.Hour), 10).
+					Return(int64(0), errors.New("db error")).Once()
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := new(MockCleanupStorage)
+			tt.mockSetup(storage)
+
+			logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+			cleaner := outbox.NewCleaner(storage, nil, tt.cfg, logger)
+
+			deleted, err := cleaner.RunOnce(context.Background())
+			require.Equal(t, tt.expectedError, err != nil)
+			require.Equal(t, tt.expected, deleted)
+
+			storage.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec serializes typed events, see Emitter. The content type of the codec is recorded in the
+// content-type header of the messages, so consumers can pick the codec to deserialize them with
+// (see CodecForContentType).
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec serializes events with encoding/json. It is the default codec of an Emitter.
+type JSONCodec struct{}
+
+// ContentType returns "application/json".
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+// Marshal serializes v to JSON.
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal deserializes JSON data into v.
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtobufCodec serializes events which are Protocol Buffers messages (proto.Message).
+type ProtobufCodec struct{}
+
+// ContentType returns "application/x-protobuf".
+func (ProtobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+// Marshal serializes the proto.Message v.
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", v)
+	}
+
+	return proto.Marshal(msg)
+}
+
+// Unmarshal deserializes data into the proto.Message v.
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+
+	return proto.Unmarshal(data, msg)
+}
+
+// MessagePackCodec serializes events with MessagePack.
+type MessagePackCodec struct{}
+
+// ContentType returns "application/msgpack".
+func (MessagePackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+// Marshal serializes v to MessagePack.
+func (MessagePackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal deserializes MessagePack data into v.
+func (MessagePackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// CodecForContentType returns the built-in codec of a content type, e.g. in a consumer receiving
+// messages with a content-type header. Messages without a content type are JSON.
+func CodecForContentType(contentType string) (Codec, error) {
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}, MessagePackCodec{}} {
+		if codec.ContentType() == contentType {
+			return codec, nil
+		}
+	}
+	if contentType == "" {
+		return JSONCodec{}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported content type %q", contentType)
+}
@@ -0,0 +1,39 @@
+package outbox
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"John","email":"john@example.com"}`), 100)
+
+	for _, encoding := range []string{ContentEncodingGzip, ContentEncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := compressData(encoding, data)
+			if err != nil {
+				t.Fatalf("failed to compress data: %v", err)
+			}
+			if len(compressed) >= len(data) {
+				t.Errorf("data was not compressed: got %d bytes, want less than %d", len(compressed), len(data))
+			}
+
+			decompressed, err := Decompress(encoding, compressed)
+			if err != nil {
+				t.Fatalf("failed to decompress data: %v", err)
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Errorf("unexpected data: got %q", decompressed)
+			}
+		})
+	}
+}
+
+func TestDecompress_Errors(t *testing.T) {
+	if _, err := Decompress(ContentEncodingGzip, []byte("not gzip")); err == nil {
+		t.Error("expected an error for corrupt data")
+	}
+	if _, err := Decompress("br", []byte("data")); err == nil {
+		t.Error("expected an error for an unsupported encoding")
+	}
+}
@@ -0,0 +1,162 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// Event is implemented by typed events which describe how they are stored, see Emit. Types which
+	// do not implement it are described by an EventDescriptor instead.
+	Event interface {
+		EventType() string
+		AggregateType() string
+		AggregateID() string
+		Topic() string
+	}
+
+	// EventDescriptor describes how events of type T are stored, see Register.
+	EventDescriptor[T any] struct {
+		EventType     string
+		AggregateType string
+		Topic         string
+		// AggregateID returns the ID of the aggregate the event belongs to.
+		AggregateID func(event T) string
+		// Codec serializes the events, the codec of the Emitter if nil.
+		Codec Codec
+	}
+
+	// Inserter inserts messages within a transaction, e.g. SQLStorage, MySQLStorage or SQLiteStorage.
+	Inserter interface {
+		InsertMessage(ctx context.Context, tx Executor, msg StorageRecord, opts ...InsertOption) (uuid.UUID, error)
+	}
+
+	// Emitter stores typed events in the outbox, serializing them with a Codec. The codec is recorded
+	// in the content-type header of the messages.
+	Emitter struct {
+		storage Inserter
+		codec   Codec
+
+		mu sync.RWMutex
+		// events holds the registered event types, see Register.
+		events map[reflect.Type]registeredEvent
+	}
+
+	// EmitterOption configures an Emitter.
+	EmitterOption func(*Emitter)
+
+	// registeredEvent is an EventDescriptor without its type parameter.
+	registeredEvent struct {
+		eventType     string
+		aggregateType string
+		topic         string
+		aggregateID   func(event any) string
+		codec         Codec
+	}
+)
+
+// WithCodec sets the codec of events which do not define their own, JSONCodec by default.
+func WithCodec(codec Codec) EmitterOption {
+	return func(e *Emitter) {
+		e.codec = codec
+	}
+}
+
+// NewEmitter creates an Emitter which inserts events into storage. The storage may be nil if the
+// events are only turned into records with NewEventRecord, e.g. to insert them into a PgxStorage.
+func NewEmitter(storage Inserter, opts ...EmitterOption) *Emitter {
+	e := &Emitter{
+		storage: storage,
+		codec:   JSONCodec{},
+		events:  make(map[reflect.Type]registeredEvent),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// Register registers the descriptor of events of type T with the emitter. It takes precedence over
+// the Event methods of T, if any.
+func Register[T any](e *Emitter, d EventDescriptor[T]) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.events[reflect.TypeFor[T]()] = registeredEvent{
+		eventType:     d.EventType,
+		aggregateType: d.AggregateType,
+		topic:         d.Topic,
+		aggregateID: func(event any) string {
+			if d.AggregateID == nil {
+				return ""
+			}
+			return d.AggregateID(event.(T))
+		},
+		codec: d.Codec,
+	}
+}
+
+// Emit serializes the event and inserts it into the outbox of the emitter within tx, see
+// SQLStorage.InsertMessage.
+func Emit[T any](ctx context.Context, e *Emitter, tx Executor, event T, opts ...InsertOption) (uuid.UUID, error) {
+	if e.storage == nil {
+		return uuid.Nil, errors.New("emitter has no storage")
+	}
+
+	msg, err := NewEventRecord(e, event)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return e.storage.InsertMessage(ctx, tx, msg, opts...)
+}
+
+// NewEventRecord serializes the event into a record, which can be inserted into any storage.
+func NewEventRecord[T any](e *Emitter, event T) (StorageRecord, error) {
+	// Describing a nil event would panic in its methods or in the AggregateID of its descriptor.
+	if v := reflect.ValueOf(event); !v.IsValid() || (v.Kind() == reflect.Pointer && v.IsNil()) {
+		return StorageRecord{}, fmt.Errorf("nil %s event", reflect.TypeFor[T]())
+	}
+
+	e.mu.RLock()
+	registered, ok := e.events[reflect.TypeFor[T]()]
+	e.mu.RUnlock()
+
+	if !ok {
+		described, isEvent := any(event).(Event)
+		if !isEvent {
+			return StorageRecord{}, fmt.Errorf("event type %s is neither registered nor implements Event", reflect.TypeFor[T]())
+		}
+		registered = registeredEvent{
+			eventType:     described.EventType(),
+			aggregateType: described.AggregateType(),
+			topic:         described.Topic(),
+			aggregateID:   func(any) string { return described.AggregateID() },
+		}
+	}
+
+	codec := registered.codec
+	if codec == nil {
+		codec = e.codec
+	}
+
+	data, err := codec.Marshal(event)
+	if err != nil {
+		return StorageRecord{}, fmt.Errorf("failed to serialize %s event: %w", registered.eventType, err)
+	}
+
+	return StorageRecord{
+		EventType:     registered.eventType,
+		AggregateType: registered.aggregateType,
+		AggregateID:   registered.aggregateID(event),
+		Data:          data,
+		Topic:         registered.topic,
+		Headers:       Headers{"content-type": codec.ContentType()},
+	}, nil
+}
@@ -0,0 +1,185 @@
+package outbox
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// userCreated describes itself by implementing Event.
+type userCreated struct {
+	ID    int64  `json:"id" msgpack:"id"`
+	Name  string `json:"name" msgpack:"name"`
+	Email string `json:"email" msgpack:"email"`
+}
+
+func (userCreated) EventType() string     { return "UserCreated" }
+func (userCreated) AggregateType() string { return "User" }
+func (e userCreated) AggregateID() string { return strconv.FormatInt(e.ID, 10) }
+func (userCreated) Topic() string         { return "users" }
+
+// orderPlaced is described by an EventDescriptor.
+type orderPlaced struct {
+	OrderID string `json:"order_id" msgpack:"order_id"`
+}
+
+func TestNewEventRecord(t *testing.T) {
+	emitter := NewEmitter(nil)
+	Register(emitter, EventDescriptor[orderPlaced]{
+		EventType:     "OrderPlaced",
+		AggregateType: "Order",
+		Topic:         "orders",
+		AggregateID:   func(e orderPlaced) string { return e.OrderID },
+		Codec:         MessagePackCodec{},
+	})
+	Register(emitter, EventDescriptor[*wrapperspb.StringValue]{
+		EventType:     "NoteAdded",
+		AggregateType: "Note",
+		Topic:         "notes",
+		AggregateID:   func(e *wrapperspb.StringValue) string { return "1" },
+		Codec:         ProtobufCodec{},
+	})
+
+	tests := []struct {
+		name        string
+		record      func() (StorageRecord, error)
+		expected    StorageRecord
+		decoded     any
+		expectError bool
+	}{
+		{
+			name: "#1 Describes events implementing Event and serializes them to JSON",
+			record: func() (StorageRecord, error) {
+				return NewEventRecord(emitter, userCreated{ID: 7, Name: `John "Johnny" Doe`, Email: "john@example.com"})
+			},
+			expected: StorageRecord{EventType: "UserCreated", AggregateType: "User", AggregateID: "7", Topic: "users",
+				Headers: Headers{"content-type": "application/json"}},
+			decoded: &userCreated{ID: 7, Name: `John "Johnny" Doe`, Email: "john@example.com"},
+		},
+		{
+			name: "#2 Describes registered events with their codec",
+			record: func() (StorageRecord, error) {
+				return NewEventRecord(emitter, orderPlaced{OrderID: "42"})
+			},
+			expected: StorageRecord{EventType: "OrderPlaced", AggregateType: "Order", AggregateID: "42", Topic: "orders",
+				Headers: Headers{"content-type": "application/msgpack"}},
+			decoded: &orderPlaced{OrderID: "42"},
+		},
+		{
+			name: "#3 Serializes Protocol Buffers messages",
+			record: func() (StorageRecord, error) {
+				return NewEventRecord(emitter, wrapperspb.String("remember the milk"))
+			},
+			expected: StorageRecord{EventType: "NoteAdded", AggregateType: "Note", AggregateID: "1", Topic: "notes",
+				Headers: Headers{"content-type": "application/x-protobuf"}},
+			decoded: wrapperspb.String("remember the milk"),
+		},
+		{
+			name: "#4 Rejects unknown event types",
+			record: func() (StorageRecord, error) {
+				return NewEventRecord(emitter, struct{ Name string }{Name: "John"})
+			},
+			expectError: true,
+		},
+		{
+			name: "#5 Rejects nil events implementing Event",
+			record: func() (StorageRecord, error) {
+				return NewEventRecord(emitter, (*userCreated)(nil))
+			},
+			expectError: true,
+		},
+		{
+			name: "#6 Rejects nil registered events",
+			record: func() (StorageRecord, error) {
+				return NewEventRecord(emitter, (*wrapperspb.StringValue)(nil))
+			},
+			expectError: true,
+		},
+		{
+			name: "#7 Rejects nil events of interface types",
+			record: func() (StorageRecord, error) {
+				return NewEventRecord[Event](emitter, nil)
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := tt.record()
+			if (err != nil) != tt.expectError {
+				t.Fatalf("unexpected error: got %v, want error=%v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			data := record.Data
+			record.Data = nil
+			if !reflect.DeepEqual(record, tt.expected) {
+				t.Errorf("unexpected record: got %+v, want %+v", record, tt.expected)
+			}
+
+			codec, err := CodecForContentType(record.Headers["content-type"])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			decoded := reflect.New(reflect.TypeOf(tt.decoded).Elem()).Interface()
+			if err = codec.Unmarshal(data, decoded); err != nil {
+				t.Fatalf("failed to deserialize event: %v", err)
+			}
+			if msg, ok := tt.decoded.(proto.Message); ok {
+				if !proto.Equal(decoded.(proto.Message), msg) {
+					t.Errorf("unexpected event: got %v, want %v", decoded, msg)
+				}
+				return
+			}
+			if !reflect.DeepEqual(decoded, tt.decoded) {
+				t.Errorf("unexpected event: got %+v, want %+v", decoded, tt.decoded)
+			}
+		})
+	}
+}
+
+func TestEmit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "outbox"`).
+		WithArgs(sqlmock.AnyArg(), "UserCreated", "User", "7", []byte(`{"id":7,"name":"John","email":"john@example.com"}`),
+			"users", RecordStatusPending, nil, `{"content-type":"application/json"}`, nil, 0, "", "", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+
+	id, err := Emit(ctx, NewEmitter(storage), tx, userCreated{ID: 7, Name: "John", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id == uuid.Nil {
+		t.Error("expected a message ID")
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+package outbox
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// Keyring provides the key encryption keys of the envelope encryption, see Encrypt. Every message is
+// encrypted with its own random data key, which the keyring wraps (encrypts) with one of its keys. The
+// ID of that key is stored with the message, so keys can be rotated without losing access to older
+// messages. Keyrings backed by a KMS wrap and unwrap the data keys remotely.
+type Keyring interface {
+	// CurrentKeyID returns the ID of the key which encrypts new messages.
+	CurrentKeyID() string
+	// WrapKey encrypts a data key with the key of the given ID.
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped with the key of the given ID.
+	UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+const (
+	// envelopeVersion is the first byte of encrypted message data, followed by the length of the wrapped
+	// data key (2 bytes, big endian), the wrapped data key, the nonce and the encrypted data.
+	envelopeVersion = 1
+	// dataKeySize is the size of the AES-256 data keys.
+	dataKeySize = 32
+)
+
+// encryptData encrypts the data of the message with the given ID with a new data key, and returns the
+// envelope together with the ID of the key which wrapped the data key. The message ID is authenticated
+// with the data, so the envelope cannot be moved to another message.
+func encryptData(keyring Keyring, id uuid.UUID, data []byte) (string, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	keyID := keyring.CurrentKeyID()
+	wrappedKey, err := keyring.WrapKey(keyID, dataKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	envelope, err := newEnvelope(wrappedKey, aead.NonceSize()+len(data)+aead.Overhead())
+	if err != nil {
+		return "", nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	envelope = append(envelope, nonce...)
+
+	return keyID, aead.Seal(envelope, nonce, data, id[:]), nil
+}
+
+// decryptData decrypts the envelope of the message with the given ID, whose data key was wrapped with
+// the key of keyID.
+func decryptData(keyring Keyring, keyID string, id uuid.UUID, envelope []byte) ([]byte, error) {
+	wrappedKey, ciphertext, err := splitEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := keyring.UnwrapKey(keyID, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("failed to decrypt data: envelope too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, id[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data: %w", err)
+	}
+
+	return data, nil
+}
+
+// rewrapData wraps the data key of an envelope with the current key of the keyring instead of the key
+// of keyID, leaving the encrypted data untouched. It returns the new key ID and envelope.
+func rewrapData(keyring Keyring, keyID string, envelope []byte) (string, []byte, error) {
+	wrappedKey, ciphertext, err := splitEnvelope(envelope)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dataKey, err := keyring.UnwrapKey(keyID, wrappedKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	newKeyID := keyring.CurrentKeyID()
+	if wrappedKey, err = keyring.WrapKey(newKeyID, dataKey); err != nil {
+		return "", nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	rewrapped, err := newEnvelope(wrappedKey, len(ciphertext))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return newKeyID, append(rewrapped, ciphertext...), nil
+}
+
+// newEnvelope starts an envelope with the wrapped data key, leaving room for size more bytes.
+func newEnvelope(wrappedKey []byte, size int) ([]byte, error) {
+	if len(wrappedKey) > math.MaxUint16 {
+		return nil, errors.New("failed to wrap data key: wrapped key too long")
+	}
+
+	envelope := make([]byte, 3, 3+len(wrappedKey)+size)
+	envelope[0] = envelopeVersion
+	binary.BigEndian.PutUint16(envelope[1:3], uint16(len(wrappedKey)))
+
+	return append(envelope, wrappedKey...), nil
+}
+
+// splitEnvelope splits an envelope into the wrapped data key and the nonce followed by the encrypted data.
+func splitEnvelope(envelope []byte) ([]byte, []byte, error) {
+	if len(envelope) < 3 || envelope[0] != envelopeVersion {
+		return nil, nil, errors.New("failed to decrypt data: unsupported envelope")
+	}
+
+	size := int(binary.BigEndian.Uint16(envelope[1:3]))
+	if len(envelope) < 3+size {
+		return nil, nil, errors.New("failed to decrypt data: envelope too short")
+	}
+
+	return envelope[3 : 3+size], envelope[3+size:], nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return aead, nil
+}
+
+// FileKeyring is a Keyring whose AES keys are read from a local JSON file, e.g. a mounted secret:
+//
+//	{
+//	  "current_key_id": "2024-06",
+//	  "keys": {
+//	    "2024-01": "<base64 encoded key>",
+//	    "2024-06": "<base64 encoded key>"
+//	  }
+//	}
+//
+// Keys are 16, 24 or 32 bytes long. To rotate keys, add a new key, make it the current one and
+// re-encrypt the pending messages (see SQLStorage.ReencryptMessages) before removing the old key.
+type FileKeyring struct {
+	currentKeyID string
+	keys         map[string]cipher.AEAD
+}
+
+// NewFileKeyring reads a FileKeyring from the file at path.
+func NewFileKeyring(path string) (*FileKeyring, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file: %w", err)
+	}
+
+	var file struct {
+		CurrentKeyID string            `json:"current_key_id"`
+		Keys         map[string]string `json:"keys"`
+	}
+	if err = json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring file: %w", err)
+	}
+	if _, ok := file.Keys[file.CurrentKeyID]; !ok {
+		return nil, fmt.Errorf("current key %q is missing in keyring file", file.CurrentKeyID)
+	}
+
+	keyring := &FileKeyring{
+		currentKeyID: file.CurrentKeyID,
+		keys:         make(map[string]cipher.AEAD, len(file.Keys)),
+	}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q: %w", id, err)
+		}
+		if keyring.keys[id], err = newAEAD(key); err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+	}
+
+	return keyring, nil
+}
+
+// CurrentKeyID returns the ID of the key which encrypts new messages.
+func (k *FileKeyring) CurrentKeyID() string {
+	return k.currentKeyID
+}
+
+// WrapKey encrypts a data key with AES-GCM using the key of the given ID.
+func (k *FileKeyring) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey with the key of the given ID.
+func (k *FileKeyring) UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+
+	return aead.Open(nil, wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():], []byte(keyID))
+}
+
+// ReencryptMessages re-wraps the data keys of up to limit pending or blocked messages which are
+// encrypted with another key than the current key of the keyring, and returns how many were updated.
+// The encrypted data itself is kept, so the relay can keep publishing the messages meanwhile as long
+// as the keyring holds both keys. Rows locked by others are skipped.
+func (s *SQLStorage) ReencryptMessages(ctx context.Context, keyring Keyring, limit int) (int, error) {
+	const selectQuery = `
+		SELECT id, key_id, data
+		FROM {table}
+		WHERE status IN ($1, $2) AND key_id <> '' AND key_id <> $3
+		ORDER BY seq
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	`
+	const updateQuery = `
+		UPDATE {table}
+		SET data = $1, key_id = $2
+		WHERE id = $3
+	`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, s.sql(selectQuery),
+		RecordStatusPending, RecordStatusBlocked, keyring.CurrentKeyID(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch messages to re-encrypt: %w", err)
+	}
+
+	type encrypted struct {
+		id    uuid.UUID
+		keyID string
+		data  []byte
+	}
+	var messages []encrypted
+	for rows.Next() {
+		var msg encrypted
+		if err = rows.Scan(&msg.id, &msg.keyID, &msg.data); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to fetch messages to re-encrypt: %w", err)
+	}
+
+	for _, msg := range messages {
+		keyID, data, err := rewrapData(keyring, msg.keyID, msg.data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to re-encrypt message %s: %w", msg.id, err)
+		}
+		if _, err = tx.ExecContext(ctx, s.sql(updateQuery), data, keyID, msg.id); err != nil {
+			return 0, fmt.Errorf("failed to update message %s: %w", msg.id, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return len(messages), nil
+}
+
+// CountMessagesToReencrypt returns how many pending or blocked messages are encrypted with another key
+// than the current key of the keyring, including those ReencryptMessages skipped because they were locked.
+func (s *SQLStorage) CountMessagesToReencrypt(ctx context.Context, keyring Keyring) (int, error) {
+	const query = `
+		SELECT COUNT(*)
+		FROM {table}
+		WHERE status IN ($1, $2) AND key_id <> '' AND key_id <> $3
+	`
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, s.sql(query),
+		RecordStatusPending, RecordStatusBlocked, keyring.CurrentKeyID(),
+	).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count messages to re-encrypt: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,265 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// newTestKeys generates a base64 encoded AES-256 key for each ID.
+func newTestKeys(t *testing.T, keyIDs ...string) map[string]string {
+	t.Helper()
+
+	keys := make(map[string]string, len(keyIDs))
+	for _, id := range keyIDs {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+
+	return keys
+}
+
+// writeTestKeyring writes a keyring file and returns its path.
+func writeTestKeyring(t *testing.T, currentKeyID string, keys map[string]string) string {
+	t.Helper()
+
+	content, err := json.Marshal(map[string]any{"current_key_id": currentKeyID, "keys": keys})
+	if err != nil {
+		t.Fatalf("failed to encode keyring: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err = os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("failed to write keyring: %v", err)
+	}
+
+	return path
+}
+
+func newTestKeyring(t *testing.T, currentKeyID string, keys map[string]string) *FileKeyring {
+	t.Helper()
+
+	keyring, err := NewFileKeyring(writeTestKeyring(t, currentKeyID, keys))
+	if err != nil {
+		t.Fatalf("failed to read keyring: %v", err)
+	}
+
+	return keyring
+}
+
+func TestNewFileKeyring(t *testing.T) {
+	tests := []struct {
+		name         string
+		currentKeyID string
+		keys         map[string]string
+		expectError  bool
+	}{
+		{
+			name:         "#1 Reads the keys",
+			currentKeyID: "2024-06",
+			keys:         newTestKeys(t, "2024-01", "2024-06"),
+		},
+		{
+			name:         "#2 Requires the current key",
+			currentKeyID: "2024-06",
+			keys:         newTestKeys(t, "2024-01"),
+			expectError:  true,
+		},
+		{
+			name:         "#3 Rejects keys of an invalid size",
+			currentKeyID: "2024-01",
+			keys:         map[string]string{"2024-01": base64.StdEncoding.EncodeToString([]byte("short"))},
+			expectError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFileKeyring(writeTestKeyring(t, tt.currentKeyID, tt.keys))
+			if (err != nil) != tt.expectError {
+				t.Errorf("unexpected error: got %v, want error=%v", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	keys := newTestKeys(t, "2024-01", "2024-06")
+	keyring := newTestKeyring(t, "2024-01", keys)
+	id := uuid.New()
+	data := []byte(`{"email":"john@example.com"}`)
+
+	keyID, envelope, err := encryptData(keyring, id, data)
+	if err != nil {
+		t.Fatalf("failed to encrypt data: %v", err)
+	}
+	if keyID != "2024-01" || bytes.Contains(envelope, data) {
+		t.Fatalf("unexpected encryption: got key %q and envelope %q", keyID, envelope)
+	}
+
+	decrypted, err := decryptData(keyring, keyID, id, envelope)
+	if err != nil {
+		t.Fatalf("failed to decrypt data: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("unexpected data: got %q", decrypted)
+	}
+
+	// The envelope is bound to its message.
+	if _, err = decryptData(keyring, keyID, uuid.New(), envelope); err == nil {
+		t.Error("expected an error when decrypting the envelope of another message")
+	}
+
+	// After rotating the keys, the data key is wrapped with the new key.
+	rotated := newTestKeyring(t, "2024-06", keys)
+	newKeyID, rewrapped, err := rewrapData(rotated, keyID, envelope)
+	if err != nil {
+		t.Fatalf("failed to re-wrap data key: %v", err)
+	}
+	if newKeyID != "2024-06" {
+		t.Errorf("unexpected key ID: got %q", newKeyID)
+	}
+
+	delete(keys, "2024-01")
+	withoutOldKey := newTestKeyring(t, "2024-06", keys)
+	decrypted, err = decryptData(withoutOldKey, newKeyID, id, rewrapped)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("unexpected re-wrapped data: got %q, %v", decrypted, err)
+	}
+}
+
+func TestSQLStorage_ReencryptMessages(t *testing.T) {
+	keys := newTestKeys(t, "2024-01", "2024-06")
+	id := uuid.New()
+	_, envelope, err := encryptData(newTestKeyring(t, "2024-01", keys), id, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("failed to encrypt data: %v", err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, key_id, data\s+FROM "outbox".+FOR UPDATE SKIP LOCKED`).
+		WithArgs(RecordStatusPending, RecordStatusBlocked, "2024-06", 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key_id", "data"}).AddRow(id, "2024-01", envelope))
+	mock.ExpectExec(`UPDATE "outbox"\s+SET data = \$1, key_id = \$2\s+WHERE id = \$3`).
+		WithArgs(sqlmock.AnyArg(), "2024-06", id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	updated, err := storage.ReencryptMessages(context.Background(), newTestKeyring(t, "2024-06", keys), 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated != 1 {
+		t.Errorf("unexpected count: got %d, want 1", updated)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_CountMessagesToReencrypt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\)\s+FROM "outbox"\s+WHERE status IN \(\$1, \$2\) AND key_id <> '' AND key_id <> \$3`).
+		WithArgs(RecordStatusPending, RecordStatusBlocked, "2024-06").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	keyring := newTestKeyring(t, "2024-06", newTestKeys(t, "2024-01", "2024-06"))
+	remaining, err := storage.CountMessagesToReencrypt(context.Background(), keyring)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remaining != 2 {
+		t.Errorf("unexpected count: got %d, want 2", remaining)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// publisherFunc adapts a function to the Publisher interface.
+type publisherFunc func(msg *StorageRecord) error
+
+func (f publisherFunc) Publish(msg *StorageRecord) error {
+	return f(msg)
+}
+
+func TestRelay_WithDecryption(t *testing.T) {
+	ctx := context.Background()
+	keyring := newTestKeyring(t, "2024-01", newTestKeys(t, "2024-01"))
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	cfg := RelayConfig{BatchSize: 10, MaxAttempts: 3}
+
+	storage := NewMemoryStorage()
+	_, err := storage.InsertMessage(ctx, StorageRecord{
+		EventType:     "UserCreated",
+		AggregateType: "User",
+		AggregateID:   "1",
+		Data:          []byte(`{"email":"john@example.com"}`),
+		Topic:         "users",
+	}, Compress(ContentEncodingGzip), Encrypt(keyring))
+	if err != nil {
+		t.Fatalf("failed to insert message: %v", err)
+	}
+
+	var published []*StorageRecord
+	publisher := publisherFunc(func(msg *StorageRecord) error {
+		published = append(published, msg)
+		return nil
+	})
+
+	// Without a keyring the encrypted message is not published.
+	NewRelay(storage, publisher, nil, cfg, logger).processMessages(ctx)
+	if len(published) != 0 || storage.Messages()[0].Attempts != 1 {
+		t.Fatalf("unexpected publish without keyring: got %d messages", len(published))
+	}
+
+	NewRelay(storage, publisher, nil, cfg, logger, WithDecryption(keyring)).processMessages(ctx)
+	if len(published) != 1 {
+		t.Fatalf("unexpected publish: got %d messages", len(published))
+	}
+
+	// The decrypted data is still compressed, which the publisher handles.
+	data, err := Decompress(published[0].ContentEncoding, published[0].Data)
+	if err != nil || string(data) != `{"email":"john@example.com"}` || published[0].KeyID != "" {
+		t.Errorf("unexpected published message: got %q, %v", data, err)
+	}
+	if storage.Messages()[0].Status != RecordStatusSent {
+		t.Errorf("unexpected status: got %q", storage.Messages()[0].Status)
+	}
+}
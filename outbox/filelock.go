@@ -0,0 +1,81 @@
+//go:build unix
+
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// FileLockElector implements a LeaderElector based on an exclusive lock of a file, for relays running
+// on one host, e.g. next to a SQLite database. Like LeaseElector, leadership is kept until Release is
+// called or the process exits.
+type FileLockElector struct {
+	path   string
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileLockElector creates a new FileLockElector locking the file at path, which is created if needed.
+func NewFileLockElector(path string, logger *slog.Logger) *FileLockElector {
+	return &FileLockElector{
+		path:   path,
+		logger: logger,
+	}
+}
+
+// IsLeader tries to acquire the file lock without blocking.
+func (e *FileLockElector) IsLeader(_ context.Context) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		e.logger.Error("FileLock: failed to open lock file", slog.Any("error", err))
+
+		return false, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			e.logger.Info("FileLock: leadership NOT acquired")
+
+			return false, nil
+		}
+		e.logger.Error("FileLock: failed to lock file", slog.Any("error", err))
+
+		return false, fmt.Errorf("failed to lock file: %w", err)
+	}
+
+	e.logger.Info("FileLock: leadership acquired")
+	e.file = file
+
+	return true, nil
+}
+
+// Release gives up the leadership, if held.
+func (e *FileLockElector) Release() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.file == nil {
+		return nil
+	}
+
+	err := e.file.Close()
+	e.file = nil
+
+	return err
+}
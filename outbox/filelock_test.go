@@ -0,0 +1,55 @@
+//go:build unix
+
+package outbox
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLockElector_IsLeader(t *testing.T) {
+	noopLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "relay.lock")
+	first := NewFileLockElector(path, noopLogger)
+	second := NewFileLockElector(path, noopLogger)
+
+	ctx := context.Background()
+
+	steps := []struct {
+		name           string
+		elector        *FileLockElector
+		release        *FileLockElector
+		expectedLeader bool
+	}{
+		{name: "#1 First elector acquires the lock", elector: first, expectedLeader: true},
+		{name: "#2 First elector keeps the lock", elector: first, expectedLeader: true},
+		{name: "#3 Second elector does not acquire the held lock", elector: second, expectedLeader: false},
+		{name: "#4 Second elector acquires the released lock", elector: second, release: first, expectedLeader: true},
+		{name: "#5 First elector does not acquire the lock again", elector: first, expectedLeader: false},
+	}
+
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.release != nil {
+				if err := tt.release.Release(); err != nil {
+					t.Fatalf("failed to release the lock: %v", err)
+				}
+			}
+
+			isLeader, err := tt.elector.IsLeader(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if isLeader != tt.expectedLeader {
+				t.Errorf("unexpected leadership: got %v, want %v", isLeader, tt.expectedLeader)
+			}
+		})
+	}
+
+	if err := second.Release(); err != nil {
+		t.Errorf("failed to release the lock: %v", err)
+	}
+}
@@ -0,0 +1,421 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStorage is a concurrency-safe, in-memory Storage for unit tests and local development. It
+// follows the semantics of SQLStorage: messages of one aggregate are fetched in insertion order,
+// aggregates are ranked by priority, and attempts and status transitions are recorded the same way.
+//
+// There are no transactions: InsertMessages stores all messages or, on error, none of them.
+type MemoryStorage struct {
+	mu sync.Mutex
+	// records holds all messages in insertion order, which stands in for the seq column.
+	records []*StorageRecord
+	byID    map[uuid.UUID]*StorageRecord
+}
+
+// NewMemoryStorage creates a new, empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		byID: make(map[uuid.UUID]*StorageRecord),
+	}
+}
+
+// InsertMessage stores a new message and returns its ID, see SQLStorage.InsertMessage.
+func (s *MemoryStorage) InsertMessage(ctx context.Context, msg StorageRecord, opts ...InsertOption) (uuid.UUID, error) {
+	ids, err := s.InsertMessages(ctx, []StorageRecord{msg}, opts...)
+	if len(ids) == 0 {
+		return uuid.Nil, err
+	}
+
+	return ids[0], err
+}
+
+// InsertMessages stores several messages and returns their IDs in the order of msgs, see
+// SQLStorage.InsertMessages. Like messages inserted in one transaction, they share their creation time.
+func (s *MemoryStorage) InsertMessages(ctx context.Context, msgs []StorageRecord, opts ...InsertOption) ([]uuid.UUID, error) {
+	var o insertOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Validating and encoding the messages may take a while (e.g. writing offloaded payloads), so it
+	// is done before locking the storage, which would otherwise block the relay meanwhile.
+	prepared, err := o.apply(ctx, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	createdAt := time.Now()
+	ids := make([]uuid.UUID, 0, len(prepared))
+	records := make([]*StorageRecord, 0, len(prepared))
+	seen := make(map[uuid.UUID]bool, len(prepared))
+	duplicates := false
+	for _, msg := range prepared {
+		ids = append(ids, msg.ID)
+
+		if _, ok := s.byID[msg.ID]; ok || seen[msg.ID] {
+			if !o.ignoreDuplicates {
+				return nil, fmt.Errorf("failed to insert outbox messages: duplicate message ID %s", msg.ID)
+			}
+			duplicates = true
+
+			continue
+		}
+		seen[msg.ID] = true
+
+		// Only the inserted columns are taken over, the others start out like in a new row.
+		records = append(records, &StorageRecord{
+			ID:               msg.ID,
+			EventType:        msg.EventType,
+			AggregateType:    msg.AggregateType,
+			AggregateID:      msg.AggregateID,
+			Data:             append([]byte(nil), msg.Data...),
+			CreatedAt:        createdAt,
+			Status:           RecordStatusPending,
+			Topic:            msg.Topic,
+			DeduplicationKey: msg.DeduplicationKey,
+			Headers:          copyHeaders(msg.Headers),
+			AvailableAt:      copyTime(msg.AvailableAt),
+			Priority:         msg.Priority,
+			ContentEncoding:  msg.ContentEncoding,
+			KeyID:            msg.KeyID,
+			PayloadRef:       msg.PayloadRef,
+		})
+	}
+
+	for _, rec := range records {
+		s.records = append(s.records, rec)
+		s.byID[rec.ID] = rec
+	}
+
+	if duplicates {
+		return ids, ErrMessageExists
+	}
+
+	return ids, nil
+}
+
+// FetchPendingMessages retrieves the oldest pending message of each aggregate, see
+// SQLStorage.FetchPendingMessages. The returned records are copies.
+func (s *MemoryStorage) FetchPendingMessages(_ context.Context, batchSize int) ([]*StorageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type aggregateKey struct{ aggregateType, aggregateID string }
+	type candidate struct {
+		rec      *StorageRecord
+		seq      int
+		priority int
+	}
+
+	// Records are kept in insertion order, and creation times never decrease, so the first pending or
+	// blocked record of an aggregate is its oldest.
+	heads := make(map[aggregateKey]*candidate)
+	var order []aggregateKey
+	for seq, rec := range s.records {
+		if rec.Status != RecordStatusPending && rec.Status != RecordStatusBlocked {
+			continue
+		}
+
+		key := aggregateKey{rec.AggregateType, rec.AggregateID}
+		head, ok := heads[key]
+		if !ok {
+			heads[key] = &candidate{rec: rec, seq: seq, priority: rec.Priority}
+			order = append(order, key)
+
+			continue
+		}
+		head.priority = max(head.priority, rec.Priority)
+	}
+
+	now := time.Now()
+	candidates := make([]*candidate, 0, len(order))
+	for _, key := range order {
+		head := heads[key]
+		if head.rec.Status != RecordStatusPending ||
+			(head.rec.AvailableAt != nil && head.rec.AvailableAt.After(now)) ||
+			(head.rec.NextAttemptAt != nil && head.rec.NextAttemptAt.After(now)) {
+			continue
+		}
+		candidates = append(candidates, head)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority > candidates[j].priority
+		}
+		return candidates[i].seq < candidates[j].seq
+	})
+
+	var records []*StorageRecord
+	for _, c := range candidates[:min(max(batchSize, 0), len(candidates))] {
+		records = append(records, copyRecord(c.rec))
+	}
+
+	return records, nil
+}
+
+// MarkMessageSent marks a message as successfully sent.
+func (s *MemoryStorage) MarkMessageSent(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.record(id)
+	if err != nil {
+		return fmt.Errorf("failed to update message status to sent: %w", err)
+	}
+	if rec == nil {
+		return errors.New("no rows updated")
+	}
+
+	now := time.Now()
+	rec.Status = RecordStatusSent
+	rec.SentAt = &now
+
+	return nil
+}
+
+// IncrementAttempt increments the attempt count for a message, records the reason of the failed
+// attempt and defers the next attempt by retryAfter.
+func (s *MemoryStorage) IncrementAttempt(_ context.Context, id string, retryAfter time.Duration, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.record(id)
+	if err != nil {
+		return fmt.Errorf("failed to increment attempt count: %w", err)
+	}
+	if rec == nil {
+		return nil
+	}
+
+	now := time.Now()
+	nextAttemptAt := now.Add(retryAfter)
+	rec.Attempts++
+	rec.NextAttemptAt = &nextAttemptAt
+	rec.LastError = &reason
+	rec.LastErrorAt = &now
+
+	return nil
+}
+
+// MarkMessageDead marks a message as dead (failed permanently), see SQLStorage.MarkMessageDead.
+func (s *MemoryStorage) MarkMessageDead(_ context.Context, id string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.record(id)
+	if err != nil {
+		return fmt.Errorf("failed to mark message as dead: %w", err)
+	}
+	if rec == nil {
+		return nil
+	}
+
+	now := time.Now()
+	rec.Status = RecordStatusDead
+	rec.SentAt = &now
+	recordFailure(rec, reason, now)
+
+	return nil
+}
+
+// MarkMessageBlocked marks a failed message as blocked, see SQLStorage.MarkMessageBlocked.
+func (s *MemoryStorage) MarkMessageBlocked(_ context.Context, id string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.record(id)
+	if err != nil {
+		return fmt.Errorf("failed to mark message as blocked: %w", err)
+	}
+	if rec == nil {
+		return nil
+	}
+
+	rec.Status = RecordStatusBlocked
+	recordFailure(rec, reason, time.Now())
+
+	return nil
+}
+
+// MarkAggregateDead marks a message as dead together with all later pending messages of its aggregate,
+// see SQLStorage.MarkAggregateDead.
+func (s *MemoryStorage) MarkAggregateDead(_ context.Context, id string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failed, err := s.record(id)
+	if err != nil {
+		return fmt.Errorf("failed to mark aggregate as dead: %w", err)
+	}
+	if failed == nil {
+		return nil
+	}
+
+	now := time.Now()
+	followerReason := fmt.Sprintf("preceding message %s is dead", id)
+	for _, rec := range s.records {
+		if rec.Status != RecordStatusPending ||
+			rec.AggregateType != failed.AggregateType || rec.AggregateID != failed.AggregateID {
+			continue
+		}
+
+		rec.Status = RecordStatusDead
+		rec.SentAt = &now
+		if rec == failed {
+			recordFailure(rec, reason, now)
+		} else {
+			rec.LastError = &followerReason
+			rec.LastErrorAt = &now
+		}
+	}
+
+	return nil
+}
+
+// FetchBlockedMessages retrieves up to limit blocked messages, oldest first.
+func (s *MemoryStorage) FetchBlockedMessages(_ context.Context, limit int) ([]*StorageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []*StorageRecord
+	for _, rec := range s.records {
+		if len(records) == limit {
+			break
+		}
+		if rec.Status == RecordStatusBlocked {
+			records = append(records, copyRecord(rec))
+		}
+	}
+
+	return records, nil
+}
+
+// RequeueMessage makes a blocked message pending again with a fresh attempt count, see
+// SQLStorage.RequeueMessage.
+func (s *MemoryStorage) RequeueMessage(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.record(id)
+	if err != nil {
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+	if rec == nil || rec.Status != RecordStatusBlocked {
+		return ErrNotFound
+	}
+
+	rec.Status = RecordStatusPending
+	rec.Attempts = 0
+	rec.NextAttemptAt = nil
+
+	return nil
+}
+
+// SkipBlockedMessage marks a blocked message as dead, see SQLStorage.SkipBlockedMessage.
+func (s *MemoryStorage) SkipBlockedMessage(_ context.Context, id string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.record(id)
+	if err != nil {
+		return fmt.Errorf("failed to skip blocked message: %w", err)
+	}
+	if rec == nil || rec.Status != RecordStatusBlocked {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	rec.Status = RecordStatusDead
+	rec.SentAt = &now
+	recordFailure(rec, reason, now)
+
+	return nil
+}
+
+// Messages returns copies of all stored messages in insertion order, e.g. to assert on them in tests.
+func (s *MemoryStorage) Messages() []StorageRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]StorageRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		records = append(records, *copyRecord(rec))
+	}
+
+	return records
+}
+
+// record looks up a message by its ID. It returns nil if the message does not exist.
+func (s *MemoryStorage) record(id string) (*StorageRecord, error) {
+	messageID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message ID %q: %w", id, err)
+	}
+
+	return s.byID[messageID], nil
+}
+
+// recordFailure records reason in front of the error of the last failed attempt, if any.
+func recordFailure(rec *StorageRecord, reason string, at time.Time) {
+	lastError := reason
+	if rec.LastError != nil {
+		lastError = reason + ": " + *rec.LastError
+	}
+	rec.LastError = &lastError
+	rec.LastErrorAt = &at
+}
+
+// copyRecord returns a copy of rec which shares no mutable state with it.
+func copyRecord(rec *StorageRecord) *StorageRecord {
+	c := *rec
+	c.Data = append([]byte(nil), rec.Data...)
+	c.Headers = copyHeaders(rec.Headers)
+	c.SentAt = copyTime(rec.SentAt)
+	c.AvailableAt = copyTime(rec.AvailableAt)
+	c.NextAttemptAt = copyTime(rec.NextAttemptAt)
+	c.LastErrorAt = copyTime(rec.LastErrorAt)
+	if rec.LastError != nil {
+		lastError := *rec.LastError
+		c.LastError = &lastError
+	}
+
+	return &c
+}
+
+func copyHeaders(h Headers) Headers {
+	if len(h) == 0 {
+		return nil
+	}
+
+	c := make(Headers, len(h))
+	for k, v := range h {
+		c[k] = v
+	}
+
+	return c
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	c := *t
+
+	return &c
+}
@@ -0,0 +1,320 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mammadmodi/go-outbox/outbox"
+)
+
+func memoryMessage(aggregateID, eventType string) outbox.StorageRecord {
+	return outbox.StorageRecord{
+		EventType:     eventType,
+		AggregateType: "Order",
+		AggregateID:   aggregateID,
+		Data:          []byte(`{}`),
+		Topic:         "orders",
+	}
+}
+
+func fetchIDs(t *testing.T, storage *outbox.MemoryStorage) []uuid.UUID {
+	t.Helper()
+
+	records, err := storage.FetchPendingMessages(context.Background(), 10)
+	require.NoError(t, err)
+
+	ids := make([]uuid.UUID, 0, len(records))
+	for _, rec := range records {
+		ids = append(ids, rec.ID)
+	}
+
+	return ids
+}
+
+func TestMemoryStorage_InsertMessages(t *testing.T) {
+	ctx := context.Background()
+	storage := outbox.NewMemoryStorage()
+
+	msg := memoryMessage("1", "OrderPlaced")
+	msg.DeduplicationKey = "order-1-placed"
+	msg.Status = outbox.RecordStatusSent
+	msg.Attempts = 5
+	ids, err := storage.InsertMessages(ctx, []outbox.StorageRecord{msg, memoryMessage("2", "OrderPlaced")})
+	require.NoError(t, err)
+
+	// Duplicates fail the whole insert, unless they are ignored.
+	_, err = storage.InsertMessages(ctx, []outbox.StorageRecord{memoryMessage("3", "OrderPlaced"), msg})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, outbox.ErrMessageExists)
+
+	id, err := storage.InsertMessage(ctx, msg, outbox.IgnoreDuplicates())
+	require.ErrorIs(t, err, outbox.ErrMessageExists)
+	require.Equal(t, ids[0], id)
+
+	messages := storage.Messages()
+	require.Len(t, messages, 2)
+	require.Equal(t, ids[0], messages[0].ID)
+	require.Equal(t, outbox.RecordStatusPending, messages[0].Status)
+	require.Zero(t, messages[0].Attempts)
+	require.Equal(t, messages[0].CreatedAt, messages[1].CreatedAt)
+}
+
+// blockingBlobStore is a BlobStore whose Put blocks until it is released.
+type blockingBlobStore struct {
+	putting chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingBlobStore) Put(_ context.Context, key string, _ []byte) (string, error) {
+	close(s.putting)
+	<-s.release
+
+	return key, nil
+}
+
+func (s *blockingBlobStore) Get(context.Context, string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestMemoryStorage_InsertMessages_DoesNotBlockRelay(t *testing.T) {
+	ctx := context.Background()
+	storage := outbox.NewMemoryStorage()
+	store := &blockingBlobStore{putting: make(chan struct{}), release: make(chan struct{})}
+
+	inserted := make(chan error, 1)
+	go func() {
+		_, err := storage.InsertMessage(ctx, memoryMessage("1", "OrderPlaced"), outbox.ClaimCheck(store, 0))
+		inserted <- err
+	}()
+	<-store.putting
+
+	// The relay keeps fetching while the payload of the inserted message is being stored.
+	fetched := make(chan error, 1)
+	go func() {
+		_, err := storage.FetchPendingMessages(ctx, 10)
+		fetched <- err
+	}()
+	select {
+	case err := <-fetched:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("fetching messages was blocked by the insert")
+	}
+
+	close(store.release)
+	require.NoError(t, <-inserted)
+	require.Len(t, fetchIDs(t, storage), 1)
+}
+
+func TestMemoryStorage_FetchPendingMessages(t *testing.T) {
+	ctx := context.Background()
+	reason := "exceeded max attempts (3)"
+
+	tests := []struct {
+		name     string
+		messages func() []outbox.StorageRecord
+		// update changes the stored messages before the fetch.
+		update   func(storage *outbox.MemoryStorage, ids []uuid.UUID) error
+		expected []int
+	}{
+		{
+			name: "#1 Returns the oldest message of each aggregate",
+			messages: func() []outbox.StorageRecord {
+				return []outbox.StorageRecord{
+					memoryMessage("1", "OrderPlaced"), memoryMessage("2", "OrderPlaced"), memoryMessage("1", "OrderShipped"),
+				}
+			},
+			expected: []int{0, 1},
+		},
+		{
+			name: "#2 Continues with the next message once sent",
+			messages: func() []outbox.StorageRecord {
+				return []outbox.StorageRecord{
+					memoryMessage("1", "OrderPlaced"), memoryMessage("2", "OrderPlaced"), memoryMessage("1", "OrderShipped"),
+				}
+			},
+			update: func(storage *outbox.MemoryStorage, ids []uuid.UUID) error {
+				return storage.MarkMessageSent(ctx, ids[0].String())
+			},
+			expected: []int{1, 2},
+		},
+		{
+			name: "#3 Ranks aggregates by the highest priority of their messages",
+			messages: func() []outbox.StorageRecord {
+				urgent := memoryMessage("1", "OrderPaid")
+				urgent.Priority = 10
+
+				return []outbox.StorageRecord{
+					memoryMessage("2", "OrderPlaced"), memoryMessage("1", "OrderPlaced"), urgent,
+				}
+			},
+			expected: []int{1, 0},
+		},
+		{
+			name: "#4 Holds back scheduled messages and their aggregate",
+			messages: func() []outbox.StorageRecord {
+				later := time.Now().Add(time.Hour)
+				scheduled := memoryMessage("1", "ReminderDue")
+				scheduled.AvailableAt = &later
+
+				return []outbox.StorageRecord{
+					scheduled, memoryMessage("1", "OrderPlaced"), memoryMessage("2", "OrderPlaced"),
+				}
+			},
+			expected: []int{2},
+		},
+		{
+			name: "#5 Holds back messages waiting for their next attempt",
+			messages: func() []outbox.StorageRecord {
+				return []outbox.StorageRecord{memoryMessage("1", "OrderPlaced"), memoryMessage("2", "OrderPlaced")}
+			},
+			update: func(storage *outbox.MemoryStorage, ids []uuid.UUID) error {
+				return storage.IncrementAttempt(ctx, ids[0].String(), time.Hour, "publish failed")
+			},
+			expected: []int{1},
+		},
+		{
+			name: "#6 Skips aggregates whose oldest message is blocked",
+			messages: func() []outbox.StorageRecord {
+				return []outbox.StorageRecord{
+					memoryMessage("1", "OrderPlaced"), memoryMessage("1", "OrderShipped"), memoryMessage("2", "OrderPlaced"),
+				}
+			},
+			update: func(storage *outbox.MemoryStorage, ids []uuid.UUID) error {
+				return storage.MarkMessageBlocked(ctx, ids[0].String(), reason)
+			},
+			expected: []int{2},
+		},
+		{
+			name: "#7 Continues with a requeued message",
+			messages: func() []outbox.StorageRecord {
+				return []outbox.StorageRecord{memoryMessage("1", "OrderPlaced"), memoryMessage("1", "OrderShipped")}
+			},
+			update: func(storage *outbox.MemoryStorage, ids []uuid.UUID) error {
+				if err := storage.MarkMessageBlocked(ctx, ids[0].String(), reason); err != nil {
+					return err
+				}
+				return storage.RequeueMessage(ctx, ids[0].String())
+			},
+			expected: []int{0},
+		},
+		{
+			name: "#8 Continues after a dead message",
+			messages: func() []outbox.StorageRecord {
+				return []outbox.StorageRecord{memoryMessage("1", "OrderPlaced"), memoryMessage("1", "OrderShipped")}
+			},
+			update: func(storage *outbox.MemoryStorage, ids []uuid.UUID) error {
+				return storage.MarkMessageDead(ctx, ids[0].String(), reason)
+			},
+			expected: []int{1},
+		},
+		{
+			name: "#9 Skips aggregates marked dead",
+			messages: func() []outbox.StorageRecord {
+				return []outbox.StorageRecord{
+					memoryMessage("1", "OrderPlaced"), memoryMessage("1", "OrderShipped"), memoryMessage("2", "OrderPlaced"),
+				}
+			},
+			update: func(storage *outbox.MemoryStorage, ids []uuid.UUID) error {
+				return storage.MarkAggregateDead(ctx, ids[0].String(), reason)
+			},
+			expected: []int{2},
+		},
+		{
+			name: "#10 Continues after a skipped message",
+			messages: func() []outbox.StorageRecord {
+				return []outbox.StorageRecord{memoryMessage("1", "OrderPlaced"), memoryMessage("1", "OrderShipped")}
+			},
+			update: func(storage *outbox.MemoryStorage, ids []uuid.UUID) error {
+				if err := storage.MarkMessageBlocked(ctx, ids[0].String(), reason); err != nil {
+					return err
+				}
+				return storage.SkipBlockedMessage(ctx, ids[0].String(), reason)
+			},
+			expected: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := outbox.NewMemoryStorage()
+			ids, err := storage.InsertMessages(ctx, tt.messages())
+			require.NoError(t, err)
+
+			if tt.update != nil {
+				require.NoError(t, tt.update(storage, ids))
+			}
+
+			expected := make([]uuid.UUID, 0, len(tt.expected))
+			for _, i := range tt.expected {
+				expected = append(expected, ids[i])
+			}
+			require.Equal(t, expected, fetchIDs(t, storage))
+		})
+	}
+}
+
+// recordingPublisher fails the first attempt of every message and records the successful publishes.
+type recordingPublisher struct {
+	mu        sync.Mutex
+	attempted map[uuid.UUID]bool
+	published []string
+}
+
+func (p *recordingPublisher) Publish(msg *outbox.StorageRecord) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.attempted[msg.ID] {
+		p.attempted[msg.ID] = true
+
+		return errors.New("nats: timeout")
+	}
+	p.published = append(p.published, msg.AggregateID+"/"+msg.EventType)
+
+	return nil
+}
+
+func TestRelay_WithMemoryStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := outbox.NewMemoryStorage()
+	_, err := storage.InsertMessages(ctx, []outbox.StorageRecord{
+		memoryMessage("1", "OrderPlaced"),
+		memoryMessage("2", "OrderPlaced"),
+		memoryMessage("1", "OrderShipped"),
+	})
+	require.NoError(t, err)
+
+	publisher := &recordingPublisher{attempted: make(map[uuid.UUID]bool)}
+	cfg := outbox.RelayConfig{
+		PollInterval: 5 * time.Millisecond,
+		BatchSize:    10,
+		MaxAttempts:  3,
+	}
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	relay := outbox.NewRelay(storage, publisher, nil, cfg, logger)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		relay.ShutDown()
+	}()
+	require.NoError(t, relay.Start(ctx))
+
+	// Every message failed once and was retried, while the order within each aggregate was kept.
+	require.Equal(t, []string{"1/OrderPlaced", "2/OrderPlaced", "1/OrderShipped"}, publisher.published)
+	for _, msg := range storage.Messages() {
+		require.Equal(t, outbox.RecordStatusSent, msg.Status)
+		require.Equal(t, 1, msg.Attempts)
+		require.Equal(t, "nats: timeout", *msg.LastError)
+	}
+}
@@ -0,0 +1,281 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration is a single versioned step of the outbox schema. Applied migrations must never be
+// edited; schema changes are always added as a new migration at the end of outboxMigrations.
+// Queries are templates expanded by SQLStorage.sql.
+type migration struct {
+	version     int
+	description string
+	query       string
+	// partitionedQuery replaces query when the storage uses WithPartitioning.
+	partitionedQuery string
+}
+
+var outboxMigrations = []migration{
+	{
+		version:     1,
+		description: "create outbox table",
+		query: `
+		CREATE TABLE IF NOT EXISTS {table} (
+			id UUID PRIMARY KEY,
+			event_type TEXT NOT NULL,
+			aggregate_type TEXT NOT NULL,
+			aggregate_id TEXT NOT NULL,
+			data BYTEA NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			sent_at TIMESTAMPTZ,
+			status TEXT NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			topic TEXT NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_{table_name}_aggregate_type_id
+		ON {table} (aggregate_type, aggregate_id);
+		`,
+		partitionedQuery: `
+		CREATE TABLE IF NOT EXISTS {table} (
+			id UUID NOT NULL,
+			event_type TEXT NOT NULL,
+			aggregate_type TEXT NOT NULL,
+			aggregate_id TEXT NOT NULL,
+			data BYTEA NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			sent_at TIMESTAMPTZ,
+			status TEXT NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			topic TEXT NOT NULL,
+			PRIMARY KEY (id, created_at)
+		) PARTITION BY RANGE (created_at);
+
+		CREATE TABLE IF NOT EXISTS {default_partition} PARTITION OF {table} DEFAULT;
+
+		-- Messages inserted before the PartitionManager first runs must not land in the default partition,
+		-- which would keep their day's partition from being created. The partitions of today and the next
+		-- three days (the default premake) are therefore created right away.
+		DO $$
+		DECLARE
+			today DATE := (now() AT TIME ZONE 'UTC')::date;
+		BEGIN
+			FOR i IN 0..3 LOOP
+				EXECUTE format(
+					'CREATE TABLE IF NOT EXISTS {schema_prefix}%I PARTITION OF {table} FOR VALUES FROM (%L) TO (%L)',
+					'{table_name}_p' || to_char(today + i, 'YYYYMMDD'),
+					(today + i)::timestamp AT TIME ZONE 'UTC',
+					(today + i + 1)::timestamp AT TIME ZONE 'UTC'
+				);
+			END LOOP;
+		END $$;
+
+		CREATE INDEX IF NOT EXISTS idx_{table_name}_aggregate_type_id
+		ON {table} (aggregate_type, aggregate_id);
+		`,
+	},
+	{
+		version:     2,
+		description: "add deduplication key",
+		query:       `ALTER TABLE {table} ADD COLUMN IF NOT EXISTS dedup_key TEXT;`,
+	},
+	{
+		version:     3,
+		description: "add message headers",
+		query:       `ALTER TABLE {table} ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}'::jsonb;`,
+	},
+	{
+		// created_at is the transaction start time, so messages inserted in one transaction share it.
+		// seq breaks those ties in insertion order.
+		version:     4,
+		description: "add insertion sequence",
+		query:       `ALTER TABLE {table} ADD COLUMN IF NOT EXISTS seq BIGSERIAL;`,
+	},
+	{
+		version:     5,
+		description: "add row claim columns",
+		query: `
+		ALTER TABLE {table}
+			ADD COLUMN IF NOT EXISTS claimed_by TEXT,
+			ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMPTZ;
+		`,
+	},
+	{
+		version:     6,
+		description: "add scheduled delivery time",
+		query:       `ALTER TABLE {table} ADD COLUMN IF NOT EXISTS available_at TIMESTAMPTZ;`,
+	},
+	{
+		version:     7,
+		description: "add next attempt time",
+		query:       `ALTER TABLE {table} ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;`,
+	},
+	{
+		version:     8,
+		description: "add last failure reason",
+		query: `
+		ALTER TABLE {table}
+			ADD COLUMN IF NOT EXISTS last_error TEXT,
+			ADD COLUMN IF NOT EXISTS last_error_at TIMESTAMPTZ;
+		`,
+	},
+	{
+		version:     9,
+		description: "add cleanup index",
+		query: `
+		CREATE INDEX IF NOT EXISTS idx_{table_name}_status_sent_at
+		ON {table} (status, sent_at);
+		`,
+	},
+	{
+		// Columns added to the outbox table later on must be added to the archive table as well.
+		version:     10,
+		description: "create archive table",
+		query: `
+		CREATE TABLE IF NOT EXISTS {archive_table} (
+			id UUID PRIMARY KEY,
+			event_type TEXT NOT NULL,
+			aggregate_type TEXT NOT NULL,
+			aggregate_id TEXT NOT NULL,
+			data BYTEA NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			sent_at TIMESTAMPTZ,
+			status TEXT NOT NULL,
+			attempts INT NOT NULL,
+			topic TEXT NOT NULL,
+			dedup_key TEXT,
+			headers JSONB NOT NULL DEFAULT '{}'::jsonb,
+			seq BIGINT NOT NULL,
+			available_at TIMESTAMPTZ,
+			next_attempt_at TIMESTAMPTZ,
+			last_error TEXT,
+			last_error_at TIMESTAMPTZ,
+			archived_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_{table_name}_archive_aggregate_type_id
+		ON {archive_table} (aggregate_type, aggregate_id);
+		`,
+	},
+	{
+		version:     11,
+		description: "add message priority",
+		query: `
+		ALTER TABLE {table} ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0;
+		ALTER TABLE {archive_table} ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		version:     12,
+		description: "add content encoding",
+		query: `
+		ALTER TABLE {table} ADD COLUMN IF NOT EXISTS content_encoding TEXT NOT NULL DEFAULT '';
+		ALTER TABLE {archive_table} ADD COLUMN IF NOT EXISTS content_encoding TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     13,
+		description: "add encryption key ID",
+		query: `
+		ALTER TABLE {table} ADD COLUMN IF NOT EXISTS key_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE {archive_table} ADD COLUMN IF NOT EXISTS key_id TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     14,
+		description: "add payload reference",
+		query: `
+		ALTER TABLE {table} ADD COLUMN IF NOT EXISTS payload_ref TEXT NOT NULL DEFAULT '';
+		ALTER TABLE {archive_table} ADD COLUMN IF NOT EXISTS payload_ref TEXT NOT NULL DEFAULT '';
+		`,
+	},
+}
+
+const (
+	// migrationLockQuery serializes migrations of one outbox table until the end of the transaction.
+	migrationLockQuery = "SELECT pg_advisory_xact_lock($1)"
+
+	createMigrationsTableQuery = `
+	CREATE TABLE IF NOT EXISTS {migrations_table} (
+		version INT PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	`
+
+	schemaVersionQuery   = "SELECT COALESCE(MAX(version), 0) FROM {migrations_table}"
+	recordMigrationQuery = "INSERT INTO {migrations_table} (version, description) VALUES ($1, $2)"
+)
+
+// Migrate brings the outbox schema up to date by applying all pending migrations in order.
+// All steps run in one transaction guarded by an advisory lock, so concurrent callers
+// (e.g. several relays starting at once) apply every migration exactly once.
+func (s *SQLStorage) Migrate(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err = tx.ExecContext(ctx, migrationLockQuery, s.lockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, s.sql(createMigrationsTableQuery)); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	current, err := s.schemaVersion(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range outboxMigrations {
+		if m.version <= current {
+			continue
+		}
+
+		if _, err = tx.ExecContext(ctx, s.sql(s.migrationQuery(m))); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.description, err)
+		}
+
+		if _, err = tx.ExecContext(ctx, s.sql(recordMigrationQuery), m.version, m.description); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migrations: %w", err)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the version of the latest applied migration, or 0 if none was applied.
+func (s *SQLStorage) SchemaVersion(ctx context.Context) (int, error) {
+	return s.schemaVersion(ctx, s.db)
+}
+
+func (s *SQLStorage) schemaVersion(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}) (int, error) {
+	var version int
+	if err := q.QueryRowContext(ctx, s.sql(schemaVersionQuery)).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// migrationQuery returns the query of m for the table layout of the storage.
+func (s *SQLStorage) migrationQuery(m migration) string {
+	if s.partitioned && m.partitionedQuery != "" {
+		return m.partitionedQuery
+	}
+
+	return m.query
+}
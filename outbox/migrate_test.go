@@ -0,0 +1,105 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSQLStorage_Migrate(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []SQLStorageOption
+		mockSetup     func(mock sqlmock.Sqlmock)
+		expectedError bool
+	}{
+		{
+			name: "#1 Applies all migrations on a fresh database",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+				for _, m := range outboxMigrations {
+					mock.ExpectExec(".+").WillReturnResult(sqlmock.NewResult(0, 0))
+					mock.ExpectExec(`INSERT INTO "outbox_schema_migrations"`).
+						WithArgs(m.version, m.description).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "#2 Skips already applied migrations",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(len(outboxMigrations)))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "#3 Creates a partitioned table",
+			opts: []SQLStorageOption{WithPartitioning()},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+				for i, m := range outboxMigrations {
+					query := ".+"
+					if i == 0 {
+						query = `PRIMARY KEY \(id, created_at\)\s+\) PARTITION BY RANGE \(created_at\);\s+` +
+							`CREATE TABLE IF NOT EXISTS "outbox_default" PARTITION OF "outbox" DEFAULT;.+` +
+							`'CREATE TABLE IF NOT EXISTS %I PARTITION OF "outbox" FOR VALUES FROM \(%L\) TO \(%L\)',\s+` +
+							`'outbox_p' \|\| to_char`
+					}
+					mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 0))
+					mock.ExpectExec(`INSERT INTO "outbox_schema_migrations"`).
+						WithArgs(m.version, m.description).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "#4 Failed migration rolls back",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+				mock.ExpectExec(".+").WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewSQLStorage(db, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectExec("SELECT pg_advisory_xact_lock\\(\\$1\\)").
+				WithArgs(storage.lockID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`CREATE TABLE IF NOT EXISTS "outbox_schema_migrations"`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			tt.mockSetup(mock)
+
+			err = storage.Migrate(context.Background())
+			if (err != nil) != tt.expectedError {
+				t.Errorf("unexpected error: got %v, want error=%v", err, tt.expectedError)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,448 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// mysqlMigrationLockTimeout is how long Migrate waits for concurrent migrations, in seconds.
+const mysqlMigrationLockTimeout = 60
+
+// mysqlMigrations are the schema migrations of MySQLStorage, see migration. MySQL does not run DDL
+// statements in transactions and the driver rejects multiple statements per query by default, so
+// every migration must be a single statement.
+var mysqlMigrations = []migration{
+	{
+		version:     1,
+		description: "create outbox table",
+		query: `
+		CREATE TABLE IF NOT EXISTS {table} (
+			seq BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			id CHAR(36) NOT NULL,
+			event_type VARCHAR(255) NOT NULL,
+			aggregate_type VARCHAR(255) NOT NULL,
+			aggregate_id VARCHAR(255) NOT NULL,
+			data LONGBLOB NOT NULL,
+			created_at DATETIME(6) NOT NULL DEFAULT (UTC_TIMESTAMP(6)),
+			sent_at DATETIME(6) NULL,
+			status VARCHAR(16) NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			topic VARCHAR(255) NOT NULL,
+			dedup_key VARCHAR(255) NULL,
+			headers JSON NOT NULL,
+			available_at DATETIME(6) NULL,
+			next_attempt_at DATETIME(6) NULL,
+			last_error TEXT NULL,
+			last_error_at DATETIME(6) NULL,
+			priority INT NOT NULL DEFAULT 0,
+			UNIQUE KEY uq_{table_name}_id (id),
+			KEY idx_{table_name}_aggregate (aggregate_type, aggregate_id, status, seq),
+			KEY idx_{table_name}_status_sent_at (status, sent_at)
+		)
+		`,
+	},
+	{
+		version:     2,
+		description: "add content encoding",
+		query:       "ALTER TABLE {table} ADD COLUMN content_encoding VARCHAR(32) NOT NULL DEFAULT ''",
+	},
+	{
+		version:     3,
+		description: "add encryption key ID",
+		query:       "ALTER TABLE {table} ADD COLUMN key_id VARCHAR(255) NOT NULL DEFAULT ''",
+	},
+	{
+		version:     4,
+		description: "add payload reference",
+		query:       "ALTER TABLE {table} ADD COLUMN payload_ref VARCHAR(1024) NOT NULL DEFAULT ''",
+	},
+}
+
+// MySQLStorage provides DB operations for the outbox pattern on MySQL 8.0.13 or later.
+//
+// It stores the same records as SQLStorage and preserves the order of messages within an aggregate,
+// but supports neither row claiming, archiving nor partitioning. All times are stored in UTC, so
+// the connection must not convert them to another time zone (e.g. parseTime=true&loc=UTC with
+// github.com/go-sql-driver/mysql).
+//
+// IgnoreDuplicates detects existing messages by the number of affected rows, so the connection must
+// not count matched rows as affected (clientFoundRows=true with github.com/go-sql-driver/mysql).
+// Otherwise ErrMessageExists is never returned.
+type MySQLStorage struct {
+	db        *sql.DB
+	tableName string
+	// replacer expands the table placeholders used in queries, see MySQLStorage.sql.
+	replacer *strings.Replacer
+}
+
+// MySQLStorageOption configures a MySQLStorage.
+type MySQLStorageOption func(*MySQLStorage) error
+
+// WithMySQLTableName overrides the outbox table name, which defaults to DefaultTableName.
+func WithMySQLTableName(name string) MySQLStorageOption {
+	return func(s *MySQLStorage) error {
+		if name == "" {
+			return nil
+		}
+		if !identifierPattern.MatchString(name) || len(name) > maxTableNameLength {
+			return fmt.Errorf("invalid outbox table name %q", name)
+		}
+		s.tableName = name
+
+		return nil
+	}
+}
+
+// NewMySQLStorage creates a new MySQLStorage instance.
+func NewMySQLStorage(db *sql.DB, opts ...MySQLStorageOption) (*MySQLStorage, error) {
+	s := &MySQLStorage{
+		db:        db,
+		tableName: DefaultTableName,
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	s.replacer = strings.NewReplacer(
+		"{table}", "`"+s.tableName+"`",
+		"{table_name}", s.tableName,
+		"{migrations_table}", "`"+s.tableName+"_schema_migrations`",
+	)
+
+	return s, nil
+}
+
+// sql expands the placeholders of a query template like SQLStorage.sql.
+func (s *MySQLStorage) sql(query string) string {
+	return s.replacer.Replace(query)
+}
+
+// Migrate brings the outbox schema up to date by applying all pending migrations in order.
+// Concurrent callers are serialized by a named lock.
+func (s *MySQLStorage) Migrate(ctx context.Context) error {
+	// Named locks belong to the session, so all statements run on one connection.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	lockName := "outbox_migrate:" + s.tableName
+	var locked sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, mysqlMigrationLockTimeout).
+		Scan(&locked); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	if locked.Int64 != 1 {
+		return errors.New("failed to acquire migration lock: timed out")
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(?)", lockName)
+	}()
+
+	const createMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS {migrations_table} (
+		version INT NOT NULL PRIMARY KEY,
+		description VARCHAR(255) NOT NULL,
+		applied_at DATETIME(6) NOT NULL DEFAULT (UTC_TIMESTAMP(6))
+	)
+	`
+	if _, err = conn.ExecContext(ctx, s.sql(createMigrationsTable)); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current int
+	if err = conn.QueryRowContext(ctx,
+		s.sql("SELECT COALESCE(MAX(version), 0) FROM {migrations_table}"),
+	).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range mysqlMigrations {
+		if m.version <= current {
+			continue
+		}
+
+		if _, err = conn.ExecContext(ctx, s.sql(m.query)); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.description, err)
+		}
+
+		if _, err = conn.ExecContext(ctx,
+			s.sql("INSERT INTO {migrations_table} (version, description) VALUES (?, ?)"),
+			m.version, m.description,
+		); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// InsertMessage inserts a new message into the outbox table and returns its ID.
+func (s *MySQLStorage) InsertMessage(ctx context.Context, tx Executor, msg StorageRecord, opts ...InsertOption) (uuid.UUID, error) {
+	ids, err := s.InsertMessages(ctx, tx, []StorageRecord{msg}, opts...)
+	if len(ids) == 0 {
+		return uuid.Nil, err
+	}
+
+	return ids[0], err
+}
+
+// InsertMessages inserts several messages into the outbox table using multi-row INSERT statements,
+// like SQLStorage.InsertMessages.
+func (s *MySQLStorage) InsertMessages(ctx context.Context, tx Executor, msgs []StorageRecord, opts ...InsertOption) ([]uuid.UUID, error) {
+	var o insertOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	prepared, err := o.apply(ctx, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	row := "(?" + strings.Repeat(", ?", strings.Count(insertColumns, ",")) + ")"
+
+	ids := make([]uuid.UUID, 0, len(prepared))
+	duplicates := false
+	for start := 0; start < len(prepared); start += maxInsertBatchSize {
+		batch := prepared[start:min(start+maxInsertBatchSize, len(prepared))]
+
+		var query strings.Builder
+		query.WriteString(s.sql("INSERT INTO {table} (" + insertColumns + ") VALUES "))
+
+		args := make([]any, 0, len(batch)*strings.Count(insertColumns, ",")+1)
+		for i, msg := range batch {
+			ids = append(ids, msg.ID)
+
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString(row)
+			args = append(args, insertArgs(msg)...)
+		}
+		if o.ignoreDuplicates {
+			// Unlike INSERT IGNORE this does not turn other errors into warnings.
+			query.WriteString(" ON DUPLICATE KEY UPDATE id = id")
+		}
+
+		result, err := tx.ExecContext(ctx, query.String(), args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert outbox messages: %w", err)
+		}
+
+		// An existing message is not updated by the statement, so it does not count as affected.
+		if o.ignoreDuplicates {
+			rowsAffected, err := result.RowsAffected()
+			if err != nil {
+				return nil, fmt.Errorf("failed to check affected rows: %w", err)
+			}
+			if rowsAffected < int64(len(batch)) {
+				duplicates = true
+			}
+		}
+	}
+
+	if duplicates {
+		return ids, ErrMessageExists
+	}
+
+	return ids, nil
+}
+
+// FetchPendingMessages retrieves pending messages like SQLStorage.FetchPendingMessages, using window
+// functions instead of DISTINCT ON to find the oldest message of each aggregate.
+func (s *MySQLStorage) FetchPendingMessages(ctx context.Context, batchSize int) ([]*StorageRecord, error) {
+	const query = `
+		SELECT ` + recordColumns + `
+		FROM (
+			SELECT ` + recordColumns + `, seq,
+				ROW_NUMBER() OVER (PARTITION BY aggregate_type, aggregate_id ORDER BY seq) AS aggregate_position,
+				MAX(priority) OVER (PARTITION BY aggregate_type, aggregate_id) AS aggregate_priority
+			FROM {table}
+			WHERE status IN (?, ?)
+		) AS next_events
+		WHERE aggregate_position = 1
+			AND status = ?
+			AND (available_at IS NULL OR available_at <= UTC_TIMESTAMP(6))
+			AND (next_attempt_at IS NULL OR next_attempt_at <= UTC_TIMESTAMP(6))
+		ORDER BY aggregate_priority DESC, seq ASC
+		LIMIT ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, s.sql(query),
+		RecordStatusPending, RecordStatusBlocked, RecordStatusPending, batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending messages: %w", err)
+	}
+
+	return scanRecords(rows)
+}
+
+// MarkMessageSent marks a message as successfully sent.
+func (s *MySQLStorage) MarkMessageSent(ctx context.Context, id string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?, sent_at = UTC_TIMESTAMP(6)
+		WHERE id = ?
+	`
+
+	result, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusSent, id)
+	if err != nil {
+		return fmt.Errorf("failed to update message status to sent: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("no rows updated")
+	}
+	return nil
+}
+
+// IncrementAttempt increments the attempt count for a message, records the reason of the failed
+// attempt and defers the next attempt by retryAfter.
+func (s *MySQLStorage) IncrementAttempt(ctx context.Context, id string, retryAfter time.Duration, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET attempts = attempts + 1,
+			next_attempt_at = UTC_TIMESTAMP(6) + INTERVAL ? MICROSECOND,
+			last_error = ?,
+			last_error_at = UTC_TIMESTAMP(6)
+		WHERE id = ?
+	`
+	if _, err := s.db.ExecContext(ctx, s.sql(query), retryAfter.Microseconds(), reason, id); err != nil {
+		return fmt.Errorf("failed to increment attempt count: %w", err)
+	}
+	return nil
+}
+
+// MarkMessageDead marks a message as dead (failed permanently), see SQLStorage.MarkMessageDead.
+func (s *MySQLStorage) MarkMessageDead(ctx context.Context, id string, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?,
+			sent_at = UTC_TIMESTAMP(6),
+			last_error = CASE WHEN last_error IS NULL THEN ? ELSE CONCAT(?, ': ', last_error) END,
+			last_error_at = UTC_TIMESTAMP(6)
+		WHERE id = ?
+	`
+	if _, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusDead, reason, reason, id); err != nil {
+		return fmt.Errorf("failed to mark message as dead: %w", err)
+	}
+	return nil
+}
+
+// MarkMessageBlocked marks a failed message as blocked, see SQLStorage.MarkMessageBlocked.
+func (s *MySQLStorage) MarkMessageBlocked(ctx context.Context, id string, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?,
+			last_error = CASE WHEN last_error IS NULL THEN ? ELSE CONCAT(?, ': ', last_error) END,
+			last_error_at = UTC_TIMESTAMP(6)
+		WHERE id = ?
+	`
+	if _, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusBlocked, reason, reason, id); err != nil {
+		return fmt.Errorf("failed to mark message as blocked: %w", err)
+	}
+	return nil
+}
+
+// MarkAggregateDead marks a message as dead together with all later pending messages of its aggregate,
+// see SQLStorage.MarkAggregateDead.
+func (s *MySQLStorage) MarkAggregateDead(ctx context.Context, id string, reason string) error {
+	// MySQL does not allow subqueries on the updated table, so the message is joined instead.
+	const query = `
+		UPDATE {table} o
+		JOIN {table} m ON m.aggregate_type = o.aggregate_type AND m.aggregate_id = o.aggregate_id
+		SET o.status = ?,
+			o.sent_at = UTC_TIMESTAMP(6),
+			o.last_error = CASE
+				WHEN o.id = m.id THEN CASE WHEN o.last_error IS NULL THEN ? ELSE CONCAT(?, ': ', o.last_error) END
+				ELSE ?
+			END,
+			o.last_error_at = UTC_TIMESTAMP(6)
+		WHERE m.id = ? AND o.status = ?
+	`
+
+	followerReason := fmt.Sprintf("preceding message %s is dead", id)
+	if _, err := s.db.ExecContext(ctx, s.sql(query),
+		RecordStatusDead, reason, reason, followerReason, id, RecordStatusPending,
+	); err != nil {
+		return fmt.Errorf("failed to mark aggregate as dead: %w", err)
+	}
+	return nil
+}
+
+// FetchBlockedMessages retrieves up to limit blocked messages, oldest first.
+func (s *MySQLStorage) FetchBlockedMessages(ctx context.Context, limit int) ([]*StorageRecord, error) {
+	const query = `
+		SELECT ` + recordColumns + `
+		FROM {table}
+		WHERE status = ?
+		ORDER BY seq ASC
+		LIMIT ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, s.sql(query), RecordStatusBlocked, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blocked messages: %w", err)
+	}
+
+	return scanRecords(rows)
+}
+
+// RequeueMessage makes a blocked message pending again, see SQLStorage.RequeueMessage.
+func (s *MySQLStorage) RequeueMessage(ctx context.Context, id string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?, attempts = 0, next_attempt_at = NULL
+		WHERE id = ? AND status = ?
+	`
+	result, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusPending, id, RecordStatusBlocked)
+	if err != nil {
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SkipBlockedMessage marks a blocked message as dead, see SQLStorage.SkipBlockedMessage.
+func (s *MySQLStorage) SkipBlockedMessage(ctx context.Context, id string, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?,
+			sent_at = UTC_TIMESTAMP(6),
+			last_error = CASE WHEN last_error IS NULL THEN ? ELSE CONCAT(?, ': ', last_error) END,
+			last_error_at = UTC_TIMESTAMP(6)
+		WHERE id = ? AND status = ?
+	`
+	result, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusDead, reason, reason, id, RecordStatusBlocked)
+	if err != nil {
+		return fmt.Errorf("failed to skip blocked message: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestMySQLStorage_Migrate(t *testing.T) {
+	tests := []struct {
+		name          string
+		mockSetup     func(mock sqlmock.Sqlmock)
+		expectedError bool
+	}{
+		{
+			name: "#1 Applies all migrations on a fresh database",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("CREATE TABLE IF NOT EXISTS `outbox_schema_migrations`").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+				for _, m := range mysqlMigrations {
+					mock.ExpectExec(".+").WillReturnResult(sqlmock.NewResult(0, 0))
+					mock.ExpectExec("INSERT INTO `outbox_schema_migrations`").
+						WithArgs(m.version, m.description).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectExec("SELECT RELEASE_LOCK\\(\\?\\)").
+					WithArgs("outbox_migrate:outbox").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
+		{
+			name: "#2 Failed migration releases the lock",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("CREATE TABLE IF NOT EXISTS `outbox_schema_migrations`").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 0\\)").
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+				mock.ExpectExec(".+").WillReturnError(errors.New("database error"))
+				mock.ExpectExec("SELECT RELEASE_LOCK\\(\\?\\)").
+					WithArgs("outbox_migrate:outbox").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewMySQLStorage(db)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			mock.ExpectQuery("SELECT GET_LOCK\\(\\?, \\?\\)").
+				WithArgs("outbox_migrate:outbox", mysqlMigrationLockTimeout).
+				WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(1))
+			tt.mockSetup(mock)
+
+			err = storage.Migrate(context.Background())
+			if (err != nil) != tt.expectedError {
+				t.Errorf("unexpected error: got %v, want error=%v", err, tt.expectedError)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestMySQLStorage_InsertMessages(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []InsertOption
+		query         string
+		rowsAffected  int64
+		expectedError error
+	}{
+		{
+			name:         "#1 Inserts all messages with one statement",
+			query:        "INSERT INTO `outbox` \\(" + insertColumns + "\\) VALUES \\(\\?(, \\?)+\\), \\(\\?(, \\?)+\\)$",
+			rowsAffected: 2,
+		},
+		{
+			name:          "#2 Reports existing messages in IgnoreDuplicates mode",
+			opts:          []InsertOption{IgnoreDuplicates()},
+			query:         "INSERT INTO `outbox` .+ ON DUPLICATE KEY UPDATE id = id$",
+			rowsAffected:  1,
+			expectedError: ErrMessageExists,
+		},
+		{
+			name:         "#3 Reports no existing messages in IgnoreDuplicates mode when all were inserted",
+			opts:         []InsertOption{IgnoreDuplicates()},
+			query:        "INSERT INTO `outbox` .+ ON DUPLICATE KEY UPDATE id = id$",
+			rowsAffected: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewMySQLStorage(db)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			msgs := []StorageRecord{
+				{ID: uuid.New(), EventType: "UserCreated", AggregateType: "User", AggregateID: "1", Topic: "users"},
+				{ID: uuid.New(), EventType: "UserUpdated", AggregateType: "User", AggregateID: "1", Topic: "users"},
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectExec(tt.query).
+				WithArgs(
+					msgs[0].ID, "UserCreated", "User", "1", []byte(nil), "users", RecordStatusPending, nil, "{}", nil, 0, "", "", "",
+					msgs[1].ID, "UserUpdated", "User", "1", []byte(nil), "users", RecordStatusPending, nil, "{}", nil, 0, "", "", "",
+				).
+				WillReturnResult(sqlmock.NewResult(0, tt.rowsAffected))
+
+			ctx := context.Background()
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatalf("failed to begin transaction: %v", err)
+			}
+
+			ids, err := storage.InsertMessages(ctx, tx, msgs, tt.opts...)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.expectedError)
+			}
+			if len(ids) != 2 || ids[0] != msgs[0].ID || ids[1] != msgs[1].ID {
+				t.Errorf("unexpected ids: got %v", ids)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestMySQLStorage_FetchPendingMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewMySQLStorage(db, WithMySQLTableName("orders_outbox"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	expected := &StorageRecord{
+		ID:            uuid.New(),
+		EventType:     "OrderPlaced",
+		AggregateType: "Order",
+		AggregateID:   "7",
+		Data:          []byte(`{}`),
+		CreatedAt:     time.Now(),
+		Status:        RecordStatusPending,
+		Topic:         "orders",
+		Headers:       Headers{"tenant": "acme"},
+		Priority:      1,
+	}
+	// The oldest message of each aggregate is selected by insertion order, among pending and blocked
+	// messages, and the aggregates are ranked by priority.
+	mock.ExpectQuery("ROW_NUMBER\\(\\) OVER \\(PARTITION BY aggregate_type, aggregate_id ORDER BY seq\\) AS aggregate_position,\\s+"+
+		"MAX\\(priority\\) OVER \\(PARTITION BY aggregate_type, aggregate_id\\) AS aggregate_priority\\s+"+
+		"FROM `orders_outbox`\\s+WHERE status IN \\(\\?, \\?\\)\\s+\\) AS next_events\\s+"+
+		"WHERE aggregate_position = 1\\s+AND status = \\?.+"+
+		"ORDER BY aggregate_priority DESC, seq ASC\\s+LIMIT \\?").
+		WithArgs(RecordStatusPending, RecordStatusBlocked, RecordStatusPending, 10).
+		WillReturnRows(recordRows(expected))
+
+	records, err := storage.FetchPendingMessages(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0], expected) {
+		t.Errorf("unexpected records: got %+v", records)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestMySQLStorage_UpdateMessages(t *testing.T) {
+	id := uuid.New().String()
+	reason := "exceeded max attempts (3)"
+
+	tests := []struct {
+		name      string
+		mockSetup func(mock sqlmock.Sqlmock)
+		call      func(s *MySQLStorage) error
+	}{
+		{
+			name: "#1 MarkMessageSent",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE `outbox`\\s+SET status = \\?, sent_at = UTC_TIMESTAMP\\(6\\)\\s+WHERE id = \\?").
+					WithArgs(RecordStatusSent, id).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(s *MySQLStorage) error {
+				return s.MarkMessageSent(context.Background(), id)
+			},
+		},
+		{
+			name: "#2 IncrementAttempt",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("next_attempt_at = UTC_TIMESTAMP\\(6\\) \\+ INTERVAL \\? MICROSECOND").
+					WithArgs(int64(1500000), "publish failed", id).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(s *MySQLStorage) error {
+				return s.IncrementAttempt(context.Background(), id, 1500*time.Millisecond, "publish failed")
+			},
+		},
+		{
+			name: "#3 MarkMessageDead",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("last_error = CASE WHEN last_error IS NULL THEN \\? ELSE CONCAT\\(\\?, ': ', last_error\\) END").
+					WithArgs(RecordStatusDead, reason, reason, id).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(s *MySQLStorage) error {
+				return s.MarkMessageDead(context.Background(), id, reason)
+			},
+		},
+		{
+			name: "#4 MarkAggregateDead",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE `outbox` o\\s+JOIN `outbox` m ON .+WHERE m.id = \\? AND o.status = \\?").
+					WithArgs(RecordStatusDead, reason, reason, "preceding message "+id+" is dead", id,
+						RecordStatusPending).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+			},
+			call: func(s *MySQLStorage) error {
+				return s.MarkAggregateDead(context.Background(), id, reason)
+			},
+		},
+		{
+			name: "#5 RequeueMessage",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("SET status = \\?, attempts = 0, next_attempt_at = NULL\\s+WHERE id = \\? AND status = \\?").
+					WithArgs(RecordStatusPending, id, RecordStatusBlocked).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(s *MySQLStorage) error {
+				return s.RequeueMessage(context.Background(), id)
+			},
+		},
+		{
+			name: "#6 SkipBlockedMessage",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE `outbox`\\s+SET status = \\?,.+WHERE id = \\? AND status = \\?").
+					WithArgs(RecordStatusDead, reason, reason, id, RecordStatusBlocked).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(s *MySQLStorage) error {
+				return s.SkipBlockedMessage(context.Background(), id, reason)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewMySQLStorage(db)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			tt.mockSetup(mock)
+			if err = tt.call(storage); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
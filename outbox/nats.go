@@ -1,6 +1,8 @@
 package outbox
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 
 	"github.com/nats-io/nats.go"
@@ -12,17 +14,38 @@ var ErrMissingTopic = nats.ErrBadSubject
 type NatsPublisher struct {
 	conn   *nats.Conn
 	logger *slog.Logger
+	// decompress publishes the original data of compressed messages, see WithDecompression.
+	decompress bool
+}
+
+// NatsPublisherOption configures a NatsPublisher.
+type NatsPublisherOption func(*NatsPublisher)
+
+// WithDecompression decompresses the data of compressed messages before publishing them, for consumers
+// which do not support compressed payloads. By default compressed data is published as is, together
+// with a content-encoding header naming its compression.
+func WithDecompression() NatsPublisherOption {
+	return func(p *NatsPublisher) {
+		p.decompress = true
+	}
 }
 
 // NewNatsPublisher creates a new NatsPublisher.
-func NewNatsPublisher(conn *nats.Conn, logger *slog.Logger) *NatsPublisher {
-	return &NatsPublisher{
+func NewNatsPublisher(conn *nats.Conn, logger *slog.Logger, opts ...NatsPublisherOption) *NatsPublisher {
+	p := &NatsPublisher{
 		conn:   conn,
 		logger: logger,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
 }
 
-// Publish sends an Outbox message to NATS, using EventType as a header.
+// Publish sends an Outbox message to NATS. The message headers are copied verbatim, while the
+// event-type, aggregate-type and aggregate-id headers always reflect the message itself. Messages
+// whose payload is kept in a BlobStore are published with a payload-ref header and without data.
 func (p *NatsPublisher) Publish(msg *StorageRecord) error {
 	if msg.Topic == "" {
 		p.logger.
@@ -31,16 +54,15 @@ func (p *NatsPublisher) Publish(msg *StorageRecord) error {
 		return ErrMissingTopic
 	}
 
-	headers := nats.Header{}
-	headers.Set("event-type", msg.EventType)
-	headers.Set("aggregate-type", msg.AggregateType)
-	headers.Set("aggregate-id", msg.AggregateID)
+	natsMsg, err := p.natsMsg(msg)
+	if err != nil {
+		p.logger.
+			With(slog.String("message_id", msg.ID.String()), slog.Any("error", err)).
+			Error("Publisher: failed to decompress message")
+		return err
+	}
 
-	if err := p.conn.PublishMsg(&nats.Msg{
-		Subject: msg.Topic,
-		Data:    msg.Data,
-		Header:  headers,
-	}); err != nil {
+	if err = p.conn.PublishMsg(natsMsg); err != nil {
 		p.logger.
 			With(slog.String("message_id", msg.ID.String()), slog.String("subject", msg.Topic), slog.Any("error", err)).
 			Error("Publisher: failed to publish to NATS")
@@ -53,3 +75,56 @@ func (p *NatsPublisher) Publish(msg *StorageRecord) error {
 
 	return nil
 }
+
+// natsMsg builds the NATS message of msg.
+func (p *NatsPublisher) natsMsg(msg *StorageRecord) (*nats.Msg, error) {
+	headers := nats.Header{}
+	for key, value := range msg.Headers {
+		headers[key] = []string{value}
+	}
+	headers.Set("event-type", msg.EventType)
+	headers.Set("aggregate-type", msg.AggregateType)
+	headers.Set("aggregate-id", msg.AggregateID)
+
+	if msg.PayloadRef != "" {
+		headers.Set("payload-ref", msg.PayloadRef)
+	}
+
+	// Offloaded payloads are not available here, so consumers decompress them after resolving them.
+	data := msg.Data
+	if msg.ContentEncoding != "" {
+		if p.decompress && msg.PayloadRef == "" {
+			var err error
+			if data, err = Decompress(msg.ContentEncoding, msg.Data); err != nil {
+				return nil, err
+			}
+		} else {
+			headers.Set("content-encoding", msg.ContentEncoding)
+		}
+	}
+
+	return &nats.Msg{
+		Subject: msg.Topic,
+		Data:    data,
+		Header:  headers,
+	}, nil
+}
+
+// ResolveNatsPayload returns the original payload of a message published by NatsPublisher, for use in
+// consumers: the payload of a claim-checked message (see ClaimCheck) is read from the blob store, and
+// compressed payloads are decompressed. The store may be nil if messages are never claim-checked.
+func ResolveNatsPayload(ctx context.Context, store BlobStore, msg *nats.Msg) ([]byte, error) {
+	data := msg.Data
+	if ref := msg.Header.Get("payload-ref"); ref != "" {
+		if store == nil {
+			return nil, fmt.Errorf("no blob store to resolve payload %q", ref)
+		}
+
+		var err error
+		if data, err = store.Get(ctx, ref); err != nil {
+			return nil, fmt.Errorf("failed to resolve payload %q: %w", ref, err)
+		}
+	}
+
+	return Decompress(msg.Header.Get("content-encoding"), data)
+}
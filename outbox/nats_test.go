@@ -0,0 +1,82 @@
+package outbox
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNatsPublisher_NatsMsg(t *testing.T) {
+	data := []byte(`{"name":"John"}`)
+	compressed, err := compressData(ContentEncodingZstd, data)
+	if err != nil {
+		t.Fatalf("failed to compress data: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		opts             []NatsPublisherOption
+		msg              StorageRecord
+		expectedData     []byte
+		expectedEncoding string
+	}{
+		{
+			name:         "#1 Publishes uncompressed data as is",
+			msg:          StorageRecord{Data: data},
+			expectedData: data,
+		},
+		{
+			name:             "#2 Publishes compressed data with a content-encoding header",
+			msg:              StorageRecord{Data: compressed, ContentEncoding: ContentEncodingZstd},
+			expectedData:     compressed,
+			expectedEncoding: ContentEncodingZstd,
+		},
+		{
+			name:         "#3 Decompresses the data",
+			opts:         []NatsPublisherOption{WithDecompression()},
+			msg:          StorageRecord{Data: compressed, ContentEncoding: ContentEncodingZstd},
+			expectedData: data,
+		},
+		{
+			name:             "#4 Leaves claim-checked payloads to the consumer",
+			opts:             []NatsPublisherOption{WithDecompression()},
+			msg:              StorageRecord{Data: []byte{}, ContentEncoding: ContentEncodingZstd, PayloadRef: "blob-1"},
+			expectedData:     []byte{},
+			expectedEncoding: ContentEncodingZstd,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := NewNatsPublisher(nil, slog.New(slog.NewJSONHandler(io.Discard, nil)), tt.opts...)
+
+			tt.msg.ID = uuid.New()
+			tt.msg.EventType = "UserCreated"
+			tt.msg.AggregateType = "User"
+			tt.msg.AggregateID = "1"
+			tt.msg.Topic = "users"
+			tt.msg.Headers = Headers{"tenant": "acme"}
+
+			natsMsg, err := publisher.natsMsg(&tt.msg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if natsMsg.Subject != "users" || !bytes.Equal(natsMsg.Data, tt.expectedData) {
+				t.Errorf("unexpected message: got subject %q and data %q", natsMsg.Subject, natsMsg.Data)
+			}
+			if natsMsg.Header.Get("content-encoding") != tt.expectedEncoding {
+				t.Errorf("unexpected content-encoding: got %q, want %q",
+					natsMsg.Header.Get("content-encoding"), tt.expectedEncoding)
+			}
+			if natsMsg.Header.Get("payload-ref") != tt.msg.PayloadRef {
+				t.Errorf("unexpected payload-ref: got %q", natsMsg.Header.Get("payload-ref"))
+			}
+			if natsMsg.Header.Get("tenant") != "acme" || natsMsg.Header.Get("event-type") != "UserCreated" {
+				t.Errorf("unexpected headers: got %v", natsMsg.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package outbox
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The listener reconnects after losing its connection, waiting up to maxListenerReconnect between attempts.
+const (
+	minListenerReconnect = time.Second
+	maxListenerReconnect = time.Minute
+)
+
+// NotifyListener listens on the Postgres channel which an SQLStorage with WithNotifyChannel notifies
+// of inserted messages, and turns the notifications into wake-ups of a relay, see WithWakeup.
+type NotifyListener struct {
+	listener *pq.Listener
+	wakeup   chan struct{}
+	logger   *slog.Logger
+}
+
+// NewNotifyListener opens a dedicated connection to the database at dsn and listens on channel. It
+// blocks until the connection is established.
+func NewNotifyListener(dsn, channel string, logger *slog.Logger) (*NotifyListener, error) {
+	l := &NotifyListener{
+		wakeup: make(chan struct{}, 1),
+		logger: logger,
+	}
+
+	l.listener = pq.NewListener(dsn, minListenerReconnect, maxListenerReconnect, l.event)
+	if err := l.listener.Listen(channel); err != nil {
+		_ = l.listener.Close()
+		return nil, fmt.Errorf("failed to listen on notify channel: %w", err)
+	}
+
+	go l.forward(l.listener.Notify)
+
+	return l, nil
+}
+
+// Wakeup returns the channel which receives a value after notifications, see WithWakeup.
+func (l *NotifyListener) Wakeup() <-chan struct{} {
+	return l.wakeup
+}
+
+// Close closes the connection of the listener.
+func (l *NotifyListener) Close() error {
+	return l.listener.Close()
+}
+
+// forward wakes up the relay for every notification until notifications is closed. Notifications
+// arriving while a wake-up is still pending are folded into it, as one poll fetches all their
+// messages. The nil notification sent after a reconnect wakes up the relay as well, since
+// notifications may have been missed in the meantime.
+func (l *NotifyListener) forward(notifications <-chan *pq.Notification) {
+	for range notifications {
+		select {
+		case l.wakeup <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// event logs the state changes of the listener's connection.
+func (l *NotifyListener) event(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		l.logger.Warn("NotifyListener: connection lost", slog.Any("error", err))
+	case pq.ListenerEventConnectionAttemptFailed:
+		l.logger.Error("NotifyListener: failed to reconnect", slog.Any("error", err))
+	case pq.ListenerEventReconnected:
+		l.logger.Info("NotifyListener: reconnected")
+	}
+}
@@ -0,0 +1,34 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestNotifyListener_Forward(t *testing.T) {
+	l := &NotifyListener{wakeup: make(chan struct{}, 1)}
+	notifications := make(chan *pq.Notification, 3)
+
+	// Notifications arriving before the relay polls are folded into one wake-up, and the nil
+	// notification of a reconnect wakes up the relay as well.
+	notifications <- &pq.Notification{Channel: "outbox"}
+	notifications <- &pq.Notification{Channel: "outbox"}
+	notifications <- nil
+	close(notifications)
+
+	l.forward(notifications)
+
+	select {
+	case <-l.Wakeup():
+	case <-time.After(time.Second):
+		t.Fatal("expected a wake-up")
+	}
+
+	select {
+	case <-l.Wakeup():
+		t.Error("expected a single wake-up")
+	default:
+	}
+}
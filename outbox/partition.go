@@ -0,0 +1,292 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// partitionNameLayout formats the day covered by a partition in its name, e.g. "outbox_p20240131".
+const partitionNameLayout = "20060102"
+
+type (
+	// Partition is a daily partition of a partitioned outbox table, see WithPartitioning.
+	Partition struct {
+		// Name is the bare table name of the partition.
+		Name string
+		// From and To bound the created_at values of the partition, To being exclusive.
+		From time.Time
+		To   time.Time
+	}
+
+	// PartitionManager periodically creates the upcoming daily partitions of a partitioned outbox
+	// table and drops the ones whose retention has passed.
+	PartitionManager struct {
+		logger  *slog.Logger
+		storage PartitionStorage
+		leader  LeaderElector
+		cfg     PartitionConfig
+		done    chan struct{}
+	}
+
+	// PartitionConfig holds the configuration for the maintenance of outbox partitions.
+	PartitionConfig struct {
+		// Interval is the interval between maintenance runs.
+		Interval time.Duration `mapstructure:"interval"`
+		// Premake is the number of daily partitions created ahead of the current one.
+		Premake int `mapstructure:"premake"`
+		// Retention is how long a partition is kept after its day has ended. Zero keeps them forever.
+		Retention time.Duration `mapstructure:"retention"`
+	}
+
+	// PartitionStorage abstracts the management of outbox partitions.
+	PartitionStorage interface {
+		// CreatePartition creates the partition covering the given day (in UTC) if it does not exist yet.
+		CreatePartition(ctx context.Context, day time.Time) (Partition, error)
+		// ListPartitions returns the daily partitions of the outbox table, oldest first.
+		ListPartitions(ctx context.Context) ([]Partition, error)
+		// DropPartition drops the given partition unless it still holds undelivered messages, and
+		// reports whether it was dropped.
+		DropPartition(ctx context.Context, p Partition) (bool, error)
+	}
+)
+
+// dailyPartition returns the partition of the outbox table covering the given day in UTC.
+func (s *SQLStorage) dailyPartition(day time.Time) Partition {
+	day = day.UTC()
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	return Partition{
+		Name: s.tableName + "_p" + from.Format(partitionNameLayout),
+		From: from,
+		To:   from.AddDate(0, 0, 1),
+	}
+}
+
+// CreatePartition creates the partition covering the given day (in UTC) if it does not exist yet.
+// It fails if the default partition already holds rows of that day.
+func (s *SQLStorage) CreatePartition(ctx context.Context, day time.Time) (Partition, error) {
+	p := s.dailyPartition(day)
+
+	// DDL statements do not accept bind parameters, the bounds are formatted by us.
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF {table} FOR VALUES FROM ('%s') TO ('%s')",
+		quoteIdentifier(s.schema, p.Name), p.From.Format(time.RFC3339), p.To.Format(time.RFC3339))
+	if _, err := s.db.ExecContext(ctx, s.sql(query)); err != nil {
+		return p, fmt.Errorf("failed to create partition %s: %w", p.Name, err)
+	}
+
+	return p, nil
+}
+
+// ListPartitions returns the daily partitions of the outbox table, oldest first. The default
+// partition is not included.
+func (s *SQLStorage) ListPartitions(ctx context.Context) ([]Partition, error) {
+	const query = `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass
+		ORDER BY c.relname
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, quoteIdentifier(s.schema, s.tableName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	defer rows.Close()
+
+	prefix := s.tableName + "_p"
+	var partitions []Partition
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+
+		day, err := time.Parse(partitionNameLayout, strings.TrimPrefix(name, prefix))
+		if !strings.HasPrefix(name, prefix) || err != nil {
+			continue
+		}
+		partitions = append(partitions, s.dailyPartition(day))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate partitions: %w", err)
+	}
+
+	return partitions, nil
+}
+
+// dropPartitionLockTimeoutQuery bounds how long DropPartition waits for the lock of the outbox table.
+const dropPartitionLockTimeoutQuery = "SET LOCAL lock_timeout = '5s'"
+
+// DropPartition drops the given partition unless it still holds messages which are neither sent
+// nor dead, and reports whether it was dropped. Dropping is serialized with migrations.
+func (s *SQLStorage) DropPartition(ctx context.Context, p Partition) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err = tx.ExecContext(ctx, migrationLockQuery, s.lockID); err != nil {
+		return false, fmt.Errorf("failed to acquire partition lock: %w", err)
+	}
+
+	// Dropping a partition locks the outbox table exclusively, and queries of the relays queue up behind
+	// the waiting DROP. Giving up after a while keeps them from stalling, the next run tries again.
+	// DETACH PARTITION CONCURRENTLY would avoid the lock, but is not allowed next to a default partition.
+	if _, err = tx.ExecContext(ctx, dropPartitionLockTimeoutQuery); err != nil {
+		return false, fmt.Errorf("failed to set lock timeout: %w", err)
+	}
+
+	// Another instance may have dropped it while we waited for the lock.
+	table := quoteIdentifier(s.schema, p.Name)
+	var exists bool
+	if err = tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check partition %s: %w", p.Name, err)
+	}
+	if !exists {
+		return false, nil
+	}
+
+	var undelivered bool
+	if err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM "+table+" WHERE status NOT IN ($1, $2))",
+		RecordStatusSent, RecordStatusDead,
+	).Scan(&undelivered); err != nil {
+		return false, fmt.Errorf("failed to check partition %s: %w", p.Name, err)
+	}
+	if undelivered {
+		return false, nil
+	}
+
+	if _, err = tx.ExecContext(ctx, "DROP TABLE "+table); err != nil {
+		return false, fmt.Errorf("failed to drop partition %s: %w", p.Name, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit: %w", err)
+	}
+
+	return true, nil
+}
+
+// NewPartitionManager creates a new PartitionManager. The leader may be nil, in which case every
+// instance maintains the partitions.
+func NewPartitionManager(
+	storage PartitionStorage, leader LeaderElector, cfg PartitionConfig, logger *slog.Logger,
+) *PartitionManager {
+	if cfg.Premake < 0 {
+		cfg.Premake = 0
+	}
+
+	return &PartitionManager{
+		storage: storage,
+		leader:  leader,
+		cfg:     cfg,
+		logger:  logger,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start starts the maintenance loop. The first run happens right away, so the upcoming partitions
+// exist before messages are inserted into them.
+func (m *PartitionManager) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	m.tick(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			m.logger.Info("PartitionManager: context canceled, stopping")
+			return ctx.Err()
+		case <-m.done:
+			m.logger.Info("PartitionManager: done signal received, stopping")
+			return nil
+		case <-ticker.C:
+			m.tick(ctx)
+		}
+	}
+}
+
+func (m *PartitionManager) tick(ctx context.Context) {
+	if m.leader != nil {
+		isLeader, err := m.leader.IsLeader(ctx)
+		if err != nil {
+			m.logger.Error("PartitionManager: failed to check leadership", slog.Any("error", err))
+
+			return
+		}
+
+		if !isLeader {
+			m.logger.Debug("PartitionManager: not leader, skipping tick")
+
+			return
+		}
+	}
+
+	if err := m.RunOnce(ctx); err != nil {
+		m.logger.Error("PartitionManager: failed to maintain partitions", slog.Any("error", err))
+	}
+}
+
+// RunOnce creates the partitions of today and the next Premake days, then drops the partitions
+// whose retention has passed. Partitions still holding undelivered messages are kept. Partitions
+// which cannot be created or dropped are logged and skipped, and reported in the returned error.
+func (m *PartitionManager) RunOnce(ctx context.Context) error {
+	now := time.Now()
+
+	// A day whose partition cannot be created (e.g. because the default partition already holds rows
+	// of that day) must not keep the other days from being maintained.
+	var failed []string
+	for i := 0; i <= m.cfg.Premake; i++ {
+		day := now.AddDate(0, 0, i)
+		if _, err := m.storage.CreatePartition(ctx, day); err != nil {
+			m.logger.
+				With(slog.String("day", day.UTC().Format(time.DateOnly)), slog.Any("error", err)).
+				Error("PartitionManager: failed to create partition")
+			failed = append(failed, day.UTC().Format(time.DateOnly))
+		}
+	}
+
+	if m.cfg.Retention > 0 {
+		partitions, err := m.storage.ListPartitions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range partitions {
+			if !p.To.Add(m.cfg.Retention).Before(now) {
+				continue
+			}
+
+			logger := m.logger.With(slog.String("partition", p.Name))
+			dropped, err := m.storage.DropPartition(ctx, p)
+			switch {
+			case err != nil:
+				logger.With(slog.Any("error", err)).Error("PartitionManager: failed to drop expired partition")
+				failed = append(failed, p.Name)
+			case dropped:
+				logger.Info("PartitionManager: dropped expired partition")
+			default:
+				logger.Warn("PartitionManager: expired partition still holds undelivered messages, keeping it")
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to maintain partitions of %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// ShutDown gracefully stops the partition manager.
+func (m *PartitionManager) ShutDown() {
+	close(m.done)
+}
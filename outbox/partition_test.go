@@ -0,0 +1,110 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mammadmodi/go-outbox/outbox"
+)
+
+// MockPartitionStorage mocks PartitionStorage interface
+type MockPartitionStorage struct {
+	mock.Mock
+}
+
+func (m *MockPartitionStorage) CreatePartition(ctx context.Context, day time.Time) (outbox.Partition, error) {
+	args := m.Called(ctx, day)
+
+	return outbox.Partition{}, args.Error(0)
+}
+
+func (m *MockPartitionStorage) ListPartitions(ctx context.Context) ([]outbox.Partition, error) {
+	args := m.Called(ctx)
+
+	return args.Get(0).([]outbox.Partition), args.Error(1)
+}
+
+func (m *MockPartitionStorage) DropPartition(ctx context.Context, p outbox.Partition) (bool, error) {
+	args := m.Called(ctx, p)
+
+	return args.Bool(0), args.Error(1)
+}
+
+func TestPartitionManager_RunOnce(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	daily := func(daysAgo int) outbox.Partition {
+		from := today.AddDate(0, 0, -daysAgo)
+
+		return outbox.Partition{Name: "outbox_p" + from.Format("20060102"), From: from, To: from.AddDate(0, 0, 1)}
+	}
+
+	tests := []struct {
+		name          string
+		cfg           outbox.PartitionConfig
+		mockSetup     func(storage *MockPartitionStorage)
+		expectedError bool
+	}{
+		{
+			name: "#1 Creates upcoming partitions and drops expired ones",
+			cfg:  outbox.PartitionConfig{Premake: 2, Retention: 48 * time.Hour},
+			mockSetup: func(storage *MockPartitionStorage) {
+				storage.On("CreatePartition", mock.Anything, mock.Anything).Return(nil).Times(3)
+				storage.On("ListPartitions", mock.Anything).
+					Return([]outbox.Partition{daily(4), daily(3), daily(1), daily(0)}, nil).Once()
+				storage.On("DropPartition", mock.Anything, daily(4)).Return(true, nil).Once()
+				storage.On("DropPartition", mock.Anything, daily(3)).Return(false, nil).Once()
+			},
+		},
+		{
+			name: "#2 Zero retention keeps all partitions",
+			cfg:  outbox.PartitionConfig{Premake: 0},
+			mockSetup: func(storage *MockPartitionStorage) {
+				storage.On("CreatePartition", mock.Anything, mock.Anything).Return(nil).Once()
+			},
+		},
+		{
+			name: "#3 A failed day does not stop the run",
+			cfg:  outbox.PartitionConfig{Premake: 2, Retention: 48 * time.Hour},
+			mockSetup: func(storage *MockPartitionStorage) {
+				storage.On("CreatePartition", mock.Anything, mock.Anything).Return(errors.New("db error")).Once()
+				storage.On("CreatePartition", mock.Anything, mock.Anything).Return(nil).Twice()
+				storage.On("ListPartitions", mock.Anything).
+					Return([]outbox.Partition{daily(4), daily(3)}, nil).Once()
+				storage.On("DropPartition", mock.Anything, daily(4)).Return(false, errors.New("lock timeout")).Once()
+				storage.On("DropPartition", mock.Anything, daily(3)).Return(true, nil).Once()
+			},
+			expectedError: true,
+		},
+		{
+			name: "#4 Failing to list the partitions stops the run",
+			cfg:  outbox.PartitionConfig{Premake: 0, Retention: time.Hour},
+			mockSetup: func(storage *MockPartitionStorage) {
+				storage.On("CreatePartition", mock.Anything, mock.Anything).Return(nil).Once()
+				storage.On("ListPartitions", mock.Anything).Return([]outbox.Partition(nil), errors.New("db error")).Once()
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := new(MockPartitionStorage)
+			tt.mockSetup(storage)
+
+			logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+			manager := outbox.NewPartitionManager(storage, nil, tt.cfg, logger)
+
+			err := manager.RunOnce(context.Background())
+			require.Equal(t, tt.expectedError, err != nil)
+
+			storage.AssertExpectations(t)
+		})
+	}
+}
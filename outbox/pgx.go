@@ -0,0 +1,321 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PgxConn is the part of *pgxpool.Pool (and *pgx.Conn) used by PgxStorage.
+type PgxConn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// PgxStorage provides the DB operations of SQLStorage on top of pgx, for applications using pgx/v5 and
+// pgxpool directly instead of database/sql. It accepts the same options and runs the same queries on
+// the same schema, so it can share an outbox table with SQLStorage (e.g. one used by the relay).
+//
+// Values are exchanged in the binary protocol, and MarkMessagesSent pipelines its updates in a single
+// round trip, which the relay uses to mark all messages published in one poll at once.
+type PgxStorage struct {
+	conn PgxConn
+	// queries holds the options and query templates shared with SQLStorage; its database is not used.
+	queries *SQLStorage
+}
+
+// NewPgxStorage creates a new PgxStorage.
+func NewPgxStorage(conn PgxConn, opts ...SQLStorageOption) (*PgxStorage, error) {
+	queries, err := NewSQLStorage(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PgxStorage{
+		conn:    conn,
+		queries: queries,
+	}, nil
+}
+
+// Migrate brings the outbox schema up to date, see SQLStorage.Migrate.
+func (s *PgxStorage) Migrate(ctx context.Context) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err = tx.Exec(ctx, migrationLockQuery, s.queries.lockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	if _, err = tx.Exec(ctx, s.queries.sql(createMigrationsTableQuery)); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current int
+	if err = tx.QueryRow(ctx, s.queries.sql(schemaVersionQuery)).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range outboxMigrations {
+		if m.version <= current {
+			continue
+		}
+
+		if _, err = tx.Exec(ctx, s.queries.sql(s.queries.migrationQuery(m))); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.description, err)
+		}
+
+		if _, err = tx.Exec(ctx, s.queries.sql(recordMigrationQuery), m.version, m.description); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migrations: %w", err)
+	}
+
+	return nil
+}
+
+// InsertMessage inserts a new message into the outbox table within tx and returns its ID.
+func (s *PgxStorage) InsertMessage(ctx context.Context, tx pgx.Tx, msg StorageRecord, opts ...InsertOption) (uuid.UUID, error) {
+	ids, err := s.InsertMessages(ctx, tx, []StorageRecord{msg}, opts...)
+	if len(ids) == 0 {
+		return uuid.Nil, err
+	}
+
+	return ids[0], err
+}
+
+// InsertMessages inserts several messages into the outbox table within tx, see SQLStorage.InsertMessages.
+func (s *PgxStorage) InsertMessages(ctx context.Context, tx pgx.Tx, msgs []StorageRecord, opts ...InsertOption) ([]uuid.UUID, error) {
+	o, err := s.queries.insertOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, statements, err := s.queries.insertStatements(ctx, msgs, o)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates, inserted := false, false
+	for _, stmt := range statements {
+		tag, err := tx.Exec(ctx, stmt.query, stmt.args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert outbox messages: %w", err)
+		}
+		if o.ignoreDuplicates && tag.RowsAffected() < int64(stmt.rows) {
+			duplicates = true
+		}
+		if tag.RowsAffected() > 0 {
+			inserted = true
+		}
+	}
+
+	// Relays are only woken up if there is something new to publish, see SQLStorage.InsertMessages.
+	if s.queries.notifyChannel != "" && inserted {
+		if _, err = tx.Exec(ctx, notifyQuery, s.queries.notifyChannel); err != nil {
+			return nil, fmt.Errorf("failed to notify outbox relays: %w", err)
+		}
+	}
+
+	if duplicates {
+		return ids, ErrMessageExists
+	}
+
+	return ids, nil
+}
+
+// FetchPendingMessages retrieves the oldest pending message of each aggregate, see
+// SQLStorage.FetchPendingMessages.
+func (s *PgxStorage) FetchPendingMessages(ctx context.Context, batchSize int) ([]*StorageRecord, error) {
+	if s.queries.claimOwner != "" {
+		rows, err := s.conn.Query(ctx, s.queries.sql(claimPendingQuery),
+			RecordStatusPending, batchSize, s.queries.claimOwner, s.queries.claimLease.Seconds(), RecordStatusBlocked)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim pending messages: %w", err)
+		}
+
+		defer rows.Close()
+
+		return scanClaimed(rows)
+	}
+
+	rows, err := s.conn.Query(ctx, s.queries.sql(fetchPendingQuery), RecordStatusPending, batchSize, RecordStatusBlocked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending messages: %w", err)
+	}
+
+	return scanPgxRecords(rows)
+}
+
+// scanPgxRecords reads all rows, selected as recordColumns, and closes them.
+func scanPgxRecords(rows pgx.Rows) ([]*StorageRecord, error) {
+	defer rows.Close()
+
+	var records []*StorageRecord
+	for rows.Next() {
+		var rec StorageRecord
+		if err := rows.Scan(recordFields(&rec)...); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox record: %w", err)
+		}
+		records = append(records, &rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return records, nil
+}
+
+// sentQuery returns the query marking a message as sent, which moves it in archiving mode.
+func (s *PgxStorage) sentQuery() string {
+	if s.queries.archive {
+		return s.queries.sql(archiveSentQuery)
+	}
+
+	return s.queries.sql(markSentQuery)
+}
+
+// MarkMessageSent marks a message as successfully sent, see SQLStorage.MarkMessageSent.
+func (s *PgxStorage) MarkMessageSent(ctx context.Context, id string) error {
+	tag, err := s.conn.Exec(ctx, s.sentQuery(), RecordStatusSent, id)
+	if err != nil {
+		return fmt.Errorf("failed to update message status to sent: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("no rows updated")
+	}
+	return nil
+}
+
+// MarkMessagesSent marks several messages as successfully sent. The updates are pipelined in one round
+// trip and run in one implicit transaction, so a failing update leaves all messages pending. Messages
+// which were not found are reported after the others were marked.
+func (s *PgxStorage) MarkMessagesSent(ctx context.Context, ids []string) error {
+	query := s.sentQuery()
+	batch := &pgx.Batch{}
+	for _, id := range ids {
+		batch.Queue(query, RecordStatusSent, id)
+	}
+
+	results := s.conn.SendBatch(ctx, batch)
+	var missing []string
+	for _, id := range ids {
+		tag, err := results.Exec()
+		if err != nil {
+			_ = results.Close()
+
+			return fmt.Errorf("failed to update status of message %s to sent: %w", id, err)
+		}
+		if tag.RowsAffected() == 0 {
+			missing = append(missing, id)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("failed to update message statuses to sent: %w", err)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("no rows updated for messages %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// IncrementAttempt records a failed attempt of a message, see SQLStorage.IncrementAttempt.
+func (s *PgxStorage) IncrementAttempt(ctx context.Context, id string, retryAfter time.Duration, reason string) error {
+	if _, err := s.conn.Exec(ctx, s.queries.sql(incrementAttemptQuery), id, retryAfter.Seconds(), reason); err != nil {
+		return fmt.Errorf("failed to increment attempt count: %w", err)
+	}
+	return nil
+}
+
+// MarkMessageDead marks a message as dead, see SQLStorage.MarkMessageDead.
+func (s *PgxStorage) MarkMessageDead(ctx context.Context, id string, reason string) error {
+	query := markDeadQuery
+	if s.queries.archive {
+		query = archiveDeadQuery
+	}
+
+	if _, err := s.conn.Exec(ctx, s.queries.sql(query), RecordStatusDead, id, reason); err != nil {
+		return fmt.Errorf("failed to mark message as dead: %w", err)
+	}
+	return nil
+}
+
+// MarkMessageBlocked marks a failed message as blocked, see SQLStorage.MarkMessageBlocked.
+func (s *PgxStorage) MarkMessageBlocked(ctx context.Context, id string, reason string) error {
+	if _, err := s.conn.Exec(ctx, s.queries.sql(markBlockedQuery), RecordStatusBlocked, id, reason); err != nil {
+		return fmt.Errorf("failed to mark message as blocked: %w", err)
+	}
+	return nil
+}
+
+// MarkAggregateDead marks a message as dead together with all later pending messages of its aggregate,
+// see SQLStorage.MarkAggregateDead.
+func (s *PgxStorage) MarkAggregateDead(ctx context.Context, id string, reason string) error {
+	query := markAggregateDeadQuery
+	if s.queries.archive {
+		query = archiveAggregateDeadQuery
+	}
+
+	followerReason := fmt.Sprintf("preceding message %s is dead", id)
+	if _, err := s.conn.Exec(ctx, s.queries.sql(query),
+		RecordStatusDead, id, reason, followerReason, RecordStatusPending,
+	); err != nil {
+		return fmt.Errorf("failed to mark aggregate as dead: %w", err)
+	}
+	return nil
+}
+
+// FetchBlockedMessages retrieves up to limit blocked messages, oldest first.
+func (s *PgxStorage) FetchBlockedMessages(ctx context.Context, limit int) ([]*StorageRecord, error) {
+	rows, err := s.conn.Query(ctx, s.queries.sql(fetchBlockedQuery), RecordStatusBlocked, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blocked messages: %w", err)
+	}
+
+	return scanPgxRecords(rows)
+}
+
+// RequeueMessage makes a blocked message pending again, see SQLStorage.RequeueMessage.
+func (s *PgxStorage) RequeueMessage(ctx context.Context, id string) error {
+	tag, err := s.conn.Exec(ctx, s.queries.sql(requeueQuery), RecordStatusPending, id, RecordStatusBlocked)
+	if err != nil {
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SkipBlockedMessage marks a blocked message as dead, see SQLStorage.SkipBlockedMessage.
+func (s *PgxStorage) SkipBlockedMessage(ctx context.Context, id string, reason string) error {
+	query := skipBlockedQuery
+	if s.queries.archive {
+		query = archiveSkipBlockedQuery
+	}
+
+	tag, err := s.conn.Exec(ctx, s.queries.sql(query), RecordStatusDead, id, reason, RecordStatusBlocked)
+	if err != nil {
+		return fmt.Errorf("failed to skip blocked message: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
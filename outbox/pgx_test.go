@@ -0,0 +1,278 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pashagolub/pgxmock/v4"
+)
+
+func pgxRecordRows(records ...*StorageRecord) *pgxmock.Rows {
+	rows := pgxmock.NewRows(strings.Split(strings.ReplaceAll(recordColumns, " ", ""), ","))
+	for _, rec := range records {
+		headers, _ := rec.Headers.Value()
+		rows.AddRow(
+			rec.ID,
+			rec.EventType,
+			rec.AggregateType,
+			rec.AggregateID,
+			rec.Data,
+			rec.CreatedAt,
+			rec.Status,
+			rec.Attempts,
+			rec.Topic,
+			headers,
+			rec.AvailableAt,
+			rec.NextAttemptAt,
+			rec.LastError,
+			rec.LastErrorAt,
+			rec.SentAt,
+			rec.Priority,
+			rec.ContentEncoding,
+			rec.KeyID,
+			rec.PayloadRef,
+		)
+	}
+
+	return rows
+}
+
+func TestPgxStorage_Migrate(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create pgxmock: %v", err)
+	}
+	defer conn.Close()
+
+	storage, err := NewPgxStorage(conn)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	conn.ExpectBegin()
+	conn.ExpectExec("SELECT pg_advisory_xact_lock\\(\\$1\\)").
+		WithArgs(storage.queries.lockID).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	conn.ExpectExec("CREATE TABLE IF NOT EXISTS \"outbox_schema_migrations\"").
+		WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+	conn.ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 0\\)").
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(len(outboxMigrations) - 1))
+	last := outboxMigrations[len(outboxMigrations)-1]
+	conn.ExpectExec(".+").WillReturnResult(pgxmock.NewResult("ALTER TABLE", 0))
+	conn.ExpectExec("INSERT INTO \"outbox_schema_migrations\"").
+		WithArgs(last.version, last.description).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	conn.ExpectCommit()
+	conn.ExpectRollback()
+
+	if err = storage.Migrate(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err = conn.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPgxStorage_InsertMessages(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []InsertOption
+		query         string
+		rowsAffected  int64
+		expectedError error
+	}{
+		{
+			name:         "#1 Inserts all messages with one statement",
+			query:        "INSERT INTO \"outbox\" \\(" + insertColumns + "\\) VALUES \\(\\$1, .+\\), \\(\\$15, .+\\)$",
+			rowsAffected: 2,
+		},
+		{
+			name:          "#2 Reports existing messages in IgnoreDuplicates mode",
+			opts:          []InsertOption{IgnoreDuplicates()},
+			query:         "INSERT INTO \"outbox\" .+ ON CONFLICT DO NOTHING$",
+			rowsAffected:  1,
+			expectedError: ErrMessageExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatalf("failed to create pgxmock: %v", err)
+			}
+			defer conn.Close()
+
+			storage, err := NewPgxStorage(conn)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			msgs := []StorageRecord{
+				{ID: uuid.New(), EventType: "UserCreated", AggregateType: "User", AggregateID: "1", Topic: "users"},
+				{ID: uuid.New(), EventType: "UserUpdated", AggregateType: "User", AggregateID: "1", Topic: "users",
+					Headers: Headers{"tenant": "acme"}},
+			}
+
+			conn.ExpectBegin()
+			conn.ExpectExec(tt.query).
+				WithArgs(
+					msgs[0].ID, "UserCreated", "User", "1", []byte(nil), "users", RecordStatusPending,
+					nullString(""), Headers(nil), (*time.Time)(nil), 0, "", "", "",
+					msgs[1].ID, "UserUpdated", "User", "1", []byte(nil), "users", RecordStatusPending,
+					nullString(""), Headers{"tenant": "acme"}, (*time.Time)(nil), 0, "", "", "",
+				).
+				WillReturnResult(pgxmock.NewResult("INSERT", tt.rowsAffected))
+
+			ctx := context.Background()
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				t.Fatalf("failed to begin transaction: %v", err)
+			}
+
+			ids, err := storage.InsertMessages(ctx, tx, msgs, tt.opts...)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.expectedError)
+			}
+			if len(ids) != 2 || ids[0] != msgs[0].ID || ids[1] != msgs[1].ID {
+				t.Errorf("unexpected ids: got %v", ids)
+			}
+
+			if err = conn.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPgxStorage_FetchPendingMessages(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("failed to create pgxmock: %v", err)
+	}
+	defer conn.Close()
+
+	storage, err := NewPgxStorage(conn, WithSchema("billing"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	lastError := "publish failed"
+	expected := &StorageRecord{
+		ID:            uuid.New(),
+		EventType:     "InvoiceIssued",
+		AggregateType: "Invoice",
+		AggregateID:   "7",
+		Data:          []byte(`{}`),
+		CreatedAt:     time.Now(),
+		Status:        RecordStatusPending,
+		Attempts:      1,
+		Topic:         "invoices",
+		Headers:       Headers{"tenant": "acme"},
+		LastError:     &lastError,
+	}
+	conn.ExpectQuery("SELECT DISTINCT ON \\(aggregate_type, aggregate_id\\).+FROM \"billing\".\"outbox\"").
+		WithArgs(RecordStatusPending, 10, RecordStatusBlocked).
+		WillReturnRows(pgxRecordRows(expected))
+
+	records, err := storage.FetchPendingMessages(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0], expected) {
+		t.Errorf("unexpected records: got %+v", records)
+	}
+
+	if err = conn.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPgxStorage_MarkMessagesSent(t *testing.T) {
+	ids := []string{uuid.New().String(), uuid.New().String()}
+
+	tests := []struct {
+		name          string
+		opts          []SQLStorageOption
+		mockSetup     func(batch *pgxmock.ExpectedBatch)
+		expectedError bool
+	}{
+		{
+			name: "#1 Marks all messages in one batch",
+			mockSetup: func(batch *pgxmock.ExpectedBatch) {
+				for _, id := range ids {
+					batch.ExpectExec("UPDATE \"outbox\"\\s+SET status = \\$1, sent_at = NOW\\(\\)\\s+WHERE id = \\$2").
+						WithArgs(RecordStatusSent, id).
+						WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				}
+			},
+		},
+		{
+			name: "#2 Moves the messages to the archive in archiving mode",
+			opts: []SQLStorageOption{WithArchiving()},
+			mockSetup: func(batch *pgxmock.ExpectedBatch) {
+				for _, id := range ids {
+					batch.ExpectExec("INSERT INTO \"outbox_archive\"").
+						WithArgs(RecordStatusSent, id).
+						WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				}
+			},
+		},
+		{
+			name: "#3 Reports messages which were not found",
+			mockSetup: func(batch *pgxmock.ExpectedBatch) {
+				batch.ExpectExec("UPDATE \"outbox\"").
+					WithArgs(RecordStatusSent, ids[0]).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				batch.ExpectExec("UPDATE \"outbox\"").
+					WithArgs(RecordStatusSent, ids[1]).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+			},
+			expectedError: true,
+		},
+		{
+			name: "#4 Fails when an update fails",
+			mockSetup: func(batch *pgxmock.ExpectedBatch) {
+				batch.ExpectExec("UPDATE \"outbox\"").
+					WithArgs(RecordStatusSent, ids[0]).
+					WillReturnError(errors.New("database error"))
+				batch.ExpectExec("UPDATE \"outbox\"").
+					WithArgs(RecordStatusSent, ids[1]).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatalf("failed to create pgxmock: %v", err)
+			}
+			defer conn.Close()
+
+			storage, err := NewPgxStorage(conn, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			tt.mockSetup(conn.ExpectBatch())
+
+			err = storage.MarkMessagesSent(context.Background(), ids)
+			if (err != nil) != tt.expectedError {
+				t.Errorf("unexpected error: got %v, want error=%v", err, tt.expectedError)
+			}
+
+			if err = conn.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
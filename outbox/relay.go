@@ -2,10 +2,26 @@ package outbox
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"math/rand/v2"
 	"time"
 )
 
+// Ordering policies decide what happens to the aggregate of a message which exceeded its max attempts.
+const (
+	// OrderingPolicySkip marks the message dead and continues with the next message of its aggregate.
+	// This is the default.
+	OrderingPolicySkip = "skip"
+	// OrderingPolicyBlock marks the message blocked, holding back its aggregate until an operator
+	// requeues the message or marks it dead.
+	OrderingPolicyBlock = "block"
+	// OrderingPolicyDeadLetter marks the message dead together with all pending messages of its aggregate.
+	OrderingPolicyDeadLetter = "dead_letter"
+)
+
 type (
 	// Relay is responsible for polling the outbox table and publishing messages.
 	Relay struct {
@@ -15,8 +31,15 @@ type (
 		done      chan struct{}
 		leader    LeaderElector
 		cfg       RelayConfig
+		// keyring decrypts encrypted messages before they are published, see WithDecryption.
+		keyring Keyring
+		// wakeup triggers a poll before the next tick, see WithWakeup.
+		wakeup <-chan struct{}
 	}
 
+	// RelayOption configures a Relay.
+	RelayOption func(*Relay)
+
 	// RelayConfig holds the configuration for the outbox relay (polling loop).
 	RelayConfig struct {
 		// PollInterval is the interval between polling the outbox table.
@@ -25,14 +48,40 @@ type (
 		BatchSize int `mapstructure:"batch_size"`
 		// MaxAttempts is the maximum number of attempts to publish a message before marking it as dead.
 		MaxAttempts int `mapstructure:"max_attempts"`
+		// Backoff is the policy for delaying the next attempt after a failed publish.
+		Backoff BackoffConfig `mapstructure:"backoff"`
+		// OrderingPolicy is one of OrderingPolicySkip (the default), OrderingPolicyBlock and
+		// OrderingPolicyDeadLetter. Start fails on any other value, see Validate.
+		OrderingPolicy string `mapstructure:"ordering_policy"`
+	}
+
+	// BackoffConfig configures an exponential backoff between publish attempts of a message.
+	// The zero value disables the backoff, retrying failed messages on the next poll.
+	BackoffConfig struct {
+		// Base is the delay after the first failed attempt.
+		Base time.Duration `mapstructure:"base"`
+		// Multiplier is the factor the delay grows by with every further failed attempt.
+		Multiplier float64 `mapstructure:"multiplier"`
+		// Max caps the delay, if set.
+		Max time.Duration `mapstructure:"max"`
+		// Jitter is the fraction (between 0 and 1) of the delay which is randomly subtracted from it.
+		Jitter float64 `mapstructure:"jitter"`
 	}
 
 	// Storage abstracts DB access.
 	Storage interface {
 		FetchPendingMessages(ctx context.Context, limit int) ([]*StorageRecord, error)
 		MarkMessageSent(ctx context.Context, messageID string) error
-		IncrementAttempt(ctx context.Context, messageID string) error
-		MarkMessageDead(ctx context.Context, messageID string) error
+		IncrementAttempt(ctx context.Context, messageID string, retryAfter time.Duration, reason string) error
+		MarkMessageDead(ctx context.Context, messageID string, reason string) error
+		MarkMessageBlocked(ctx context.Context, messageID string, reason string) error
+		MarkAggregateDead(ctx context.Context, messageID string, reason string) error
+	}
+
+	// BatchStorage is optionally implemented by a Storage which marks several messages as sent in one
+	// round trip (e.g. PgxStorage). The relay then marks all messages published in a poll at once.
+	BatchStorage interface {
+		MarkMessagesSent(ctx context.Context, messageIDs []string) error
 	}
 
 	// Publisher abstracts NATS (or any broker) publishing.
@@ -46,9 +95,12 @@ type (
 	}
 )
 
-// NewRelay creates a new Relay.
-func NewRelay(storage Storage, publisher Publisher, leader LeaderElector, cfg RelayConfig, logger *slog.Logger) *Relay {
-	return &Relay{
+// NewRelay creates a new Relay. The leader may be nil when the storage coordinates concurrent relays
+// by itself (e.g. SQLStorage in row claiming mode), in which case every relay processes messages.
+func NewRelay(
+	storage Storage, publisher Publisher, leader LeaderElector, cfg RelayConfig, logger *slog.Logger, opts ...RelayOption,
+) *Relay {
+	r := &Relay{
 		storage:   storage,
 		publisher: publisher,
 		leader:    leader,
@@ -56,10 +108,47 @@ func NewRelay(storage Storage, publisher Publisher, leader LeaderElector, cfg Re
 		logger:    logger,
 		done:      make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// WithDecryption decrypts messages inserted with Encrypt before they are published. Without it,
+// publishing an encrypted message fails.
+func WithDecryption(keyring Keyring) RelayOption {
+	return func(r *Relay) {
+		r.keyring = keyring
+	}
+}
+
+// WithWakeup makes the relay poll as soon as a value is received from wakeup, e.g. from
+// NotifyListener.Wakeup, instead of waiting for the next tick. The poll interval is kept as a
+// fallback for missed wake-ups, so it can be raised to reduce the load of empty polls.
+func WithWakeup(wakeup <-chan struct{}) RelayOption {
+	return func(r *Relay) {
+		r.wakeup = wakeup
+	}
+}
+
+// Validate checks the configuration, so a misconfigured relay fails at startup instead of when
+// a message first exceeds its max attempts.
+func (c RelayConfig) Validate() error {
+	switch c.OrderingPolicy {
+	case "", OrderingPolicySkip, OrderingPolicyBlock, OrderingPolicyDeadLetter:
+		return nil
+	default:
+		return fmt.Errorf("invalid ordering policy %q", c.OrderingPolicy)
+	}
 }
 
-// Start starts the relay polling loop.
+// Start starts the relay polling loop. It fails right away if the configuration is invalid.
 func (r *Relay) Start(ctx context.Context) error {
+	if err := r.cfg.Validate(); err != nil {
+		return err
+	}
+
 	ticker := time.NewTicker(r.cfg.PollInterval)
 	defer ticker.Stop()
 
@@ -73,11 +162,22 @@ func (r *Relay) Start(ctx context.Context) error {
 			return nil
 		case <-ticker.C:
 			r.tick(ctx)
+		case <-r.wakeup:
+			r.logger.Debug("Relay: woken up, polling before the next tick")
+			r.tick(ctx)
+			// The poll just happened, so the next tick is a full interval away.
+			ticker.Reset(r.cfg.PollInterval)
 		}
 	}
 }
 
 func (r *Relay) tick(ctx context.Context) {
+	if r.leader == nil {
+		r.processMessages(ctx)
+
+		return
+	}
+
 	isLeader, err := r.leader.IsLeader(ctx)
 	if err != nil {
 		r.logger.Error("Relay: failed to check leadership", slog.Any("error", err))
@@ -108,29 +208,26 @@ func (r *Relay) processMessages(ctx context.Context) {
 		return
 	}
 
+	// Messages of one aggregate are never fetched together, so marking them after the poll keeps their order.
+	batch, _ := r.storage.(BatchStorage)
+	var sent []string
+
 	for _, msg := range messages {
 		// Check if message exceeded max attempts
 		if msg.Attempts >= r.cfg.MaxAttempts {
-			r.logger.
-				With(slog.String("message_id", msg.ID.String()), slog.Int("attempts", msg.Attempts)).
-				Warn("Relay: message exceeded max attempts, marking as dead")
-
-			if err = r.storage.MarkMessageDead(ctx, msg.ID.String()); err != nil {
-				r.logger.
-					With(slog.String("message_id", msg.ID.String()), slog.Any("error", err)).
-					Error("Relay: failed to mark message as dead")
-			}
+			r.giveUp(ctx, msg)
 
 			continue
 		}
 
-		if err = r.publisher.Publish(msg); err != nil {
+		if err = r.publish(msg); err != nil {
 			r.logger.
 				With(slog.String("message_id", msg.ID.String()), slog.Any("error", err)).
 				Error("Relay: failed to publish message")
 
-			// Increment attempt count on failure
-			if incErr := r.storage.IncrementAttempt(ctx, msg.ID.String()); incErr != nil {
+			// Increment attempt count on failure and delay the next attempt
+			retryAfter := r.cfg.Backoff.Delay(msg.Attempts + 1)
+			if incErr := r.storage.IncrementAttempt(ctx, msg.ID.String(), retryAfter, err.Error()); incErr != nil {
 				r.logger.
 					With(slog.String("message_id", msg.ID.String()), slog.Any("error", incErr)).
 					Error("Relay: failed to increment attempt count")
@@ -139,6 +236,12 @@ func (r *Relay) processMessages(ctx context.Context) {
 			continue
 		}
 
+		if batch != nil {
+			sent = append(sent, msg.ID.String())
+
+			continue
+		}
+
 		// Mark message as sent
 		if err = r.storage.MarkMessageSent(ctx, msg.ID.String()); err != nil {
 			r.logger.
@@ -151,6 +254,95 @@ func (r *Relay) processMessages(ctx context.Context) {
 		r.logger.With(slog.String("message_id", msg.ID.String())).
 			Info("Relay: successfully published and marked message")
 	}
+
+	if len(sent) == 0 {
+		return
+	}
+	if err = batch.MarkMessagesSent(ctx, sent); err != nil {
+		r.logger.
+			With(slog.Int("count", len(sent)), slog.Any("error", err)).
+			Error("Relay: failed to mark messages as sent")
+
+		return
+	}
+
+	r.logger.With(slog.Int("count", len(sent))).
+		Info("Relay: successfully published and marked messages")
+}
+
+// publish publishes msg, decrypting it first if it is encrypted.
+func (r *Relay) publish(msg *StorageRecord) error {
+	if msg.KeyID == "" {
+		return r.publisher.Publish(msg)
+	}
+	if r.keyring == nil {
+		return errors.New("message is encrypted, but the relay has no keyring")
+	}
+
+	data, err := decryptData(r.keyring, msg.KeyID, msg.ID, msg.Data)
+	if err != nil {
+		return err
+	}
+
+	decrypted := *msg
+	decrypted.Data = data
+	decrypted.KeyID = ""
+
+	return r.publisher.Publish(&decrypted)
+}
+
+// giveUp handles a message which exceeded its max attempts according to the ordering policy.
+func (r *Relay) giveUp(ctx context.Context, msg *StorageRecord) {
+	logger := r.logger.With(
+		slog.String("message_id", msg.ID.String()),
+		slog.Int("attempts", msg.Attempts),
+		slog.String("ordering_policy", r.cfg.OrderingPolicy),
+	)
+	reason := fmt.Sprintf("exceeded max attempts (%d)", msg.Attempts)
+
+	var err error
+	switch r.cfg.OrderingPolicy {
+	case "", OrderingPolicySkip:
+		logger.Warn("Relay: message exceeded max attempts, marking as dead")
+		err = r.storage.MarkMessageDead(ctx, msg.ID.String(), reason)
+	case OrderingPolicyBlock:
+		logger.Warn("Relay: message exceeded max attempts, blocking its aggregate")
+		err = r.storage.MarkMessageBlocked(ctx, msg.ID.String(), reason)
+	case OrderingPolicyDeadLetter:
+		logger.Warn("Relay: message exceeded max attempts, marking its aggregate as dead")
+		err = r.storage.MarkAggregateDead(ctx, msg.ID.String(), reason)
+	}
+
+	if err != nil {
+		logger.With(slog.Any("error", err)).Error("Relay: failed to give up on message")
+	}
+}
+
+// maxBackoffDelay is the largest float64 which still converts to a time.Duration, as
+// float64(math.MaxInt64) rounds up to 2^63.
+var maxBackoffDelay = math.Nextafter(float64(math.MaxInt64), 0)
+
+// Delay returns the backoff delay after the given number of failed attempts.
+func (b BackoffConfig) Delay(attempts int) time.Duration {
+	if b.Base <= 0 || attempts < 1 {
+		return 0
+	}
+
+	multiplier := math.Max(b.Multiplier, 1)
+	delay := float64(b.Base) * math.Pow(multiplier, float64(attempts-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	// Without a cap the delay eventually exceeds time.Duration, whose conversion would overflow.
+	if delay > maxBackoffDelay {
+		delay = maxBackoffDelay
+	}
+
+	if jitter := math.Min(math.Max(b.Jitter, 0), 1); jitter > 0 {
+		delay -= delay * jitter * rand.Float64()
+	}
+
+	return time.Duration(delay)
 }
 
 // ShutDown gracefully stops the relay.
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"math"
 	"sync"
 	"testing"
 	"time"
@@ -31,12 +32,31 @@ func (m *MockStorage) MarkMessageSent(ctx context.Context, messageID string) err
 	return m.Called(ctx, messageID).Error(0)
 }
 
-func (m *MockStorage) IncrementAttempt(ctx context.Context, messageID string) error {
-	return m.Called(ctx, messageID).Error(0)
+func (m *MockStorage) IncrementAttempt(
+	ctx context.Context, messageID string, retryAfter time.Duration, reason string,
+) error {
+	return m.Called(ctx, messageID, retryAfter, reason).Error(0)
 }
 
-func (m *MockStorage) MarkMessageDead(ctx context.Context, messageID string) error {
-	return m.Called(ctx, messageID).Error(0)
+func (m *MockStorage) MarkMessageDead(ctx context.Context, messageID string, reason string) error {
+	return m.Called(ctx, messageID, reason).Error(0)
+}
+
+func (m *MockStorage) MarkMessageBlocked(ctx context.Context, messageID string, reason string) error {
+	return m.Called(ctx, messageID, reason).Error(0)
+}
+
+func (m *MockStorage) MarkAggregateDead(ctx context.Context, messageID string, reason string) error {
+	return m.Called(ctx, messageID, reason).Error(0)
+}
+
+// MockBatchStorage mocks a Storage which implements BatchStorage
+type MockBatchStorage struct {
+	MockStorage
+}
+
+func (m *MockBatchStorage) MarkMessagesSent(ctx context.Context, messageIDs []string) error {
+	return m.Called(ctx, messageIDs).Error(0)
 }
 
 // MockPublisher mocks Publisher interface
@@ -122,6 +142,23 @@ func TestRelay_Start_TableDriven(t *testing.T) {
 				incrementAttemptCall: true,
 			},
 		},
+		{
+			name: "#5 Exceeded max attempts marks dead",
+			fields: fields{
+				isLeader: true,
+				fetchMessages: []*outbox.StorageRecord{
+					{
+						ID:            uuid.New(),
+						EventType:     "UserCreated",
+						AggregateType: "User",
+						AggregateID:   "789",
+						Data:          []byte(`{"name":"Roe"}`),
+						Attempts:      3,
+						Topic:         "user.created",
+					},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -153,11 +190,16 @@ func TestRelay_Start_TableDriven(t *testing.T) {
 				if tt.fields.fetchMessagesError == nil && len(tt.fields.fetchMessages) > 0 {
 					msg := tt.fields.fetchMessages[0]
 
-					// If publishing fails
-					if tt.fields.publishError != nil {
+					// If max attempts are exceeded
+					if msg.Attempts >= cfg.MaxAttempts {
+						storage.On("MarkMessageDead", mock.Anything, msg.ID.String(), "exceeded max attempts (3)").
+							Return(nil)
+					} else if tt.fields.publishError != nil {
+						// If publishing fails
 						publisher.On("Publish", msg).Return(tt.fields.publishError)
 						if tt.fields.incrementAttemptCall {
-							storage.On("IncrementAttempt", mock.Anything, msg.ID.String()).Return(nil)
+							storage.On("IncrementAttempt", mock.Anything, msg.ID.String(), time.Duration(0),
+								tt.fields.publishError.Error()).Return(nil)
 						}
 					} else {
 						publisher.On("Publish", msg).Return(nil)
@@ -187,3 +229,279 @@ func TestRelay_Start_TableDriven(t *testing.T) {
 		})
 	}
 }
+
+func TestRelay_Start_WithoutLeaderElector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := new(MockStorage)
+	publisher := new(MockPublisher)
+
+	cfg := outbox.RelayConfig{
+		PollInterval: 10 * time.Millisecond,
+		BatchSize:    10,
+		MaxAttempts:  3,
+	}
+
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	relay := outbox.NewRelay(storage, publisher, nil, cfg, logger)
+
+	msg := &outbox.StorageRecord{
+		ID:            uuid.New(),
+		EventType:     "UserCreated",
+		AggregateType: "User",
+		AggregateID:   "123",
+		Data:          []byte(`{"name":"John"}`),
+		Topic:         "user.created",
+	}
+
+	storage.On("FetchPendingMessages", mock.Anything, cfg.BatchSize).Return([]*outbox.StorageRecord{msg}, nil)
+	publisher.On("Publish", msg).Return(nil)
+	storage.On("MarkMessageSent", mock.Anything, msg.ID.String()).Return(nil)
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		relay.ShutDown()
+	}()
+
+	err := relay.Start(ctx)
+	require.NoError(t, err)
+
+	storage.AssertExpectations(t)
+	publisher.AssertExpectations(t)
+}
+
+func TestRelay_Start_WithWakeup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := new(MockStorage)
+	publisher := new(MockPublisher)
+
+	// The ticker does not fire during the test, so only the wake-up triggers a poll.
+	cfg := outbox.RelayConfig{
+		PollInterval: time.Hour,
+		BatchSize:    10,
+		MaxAttempts:  3,
+	}
+
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	wakeup := make(chan struct{}, 1)
+	relay := outbox.NewRelay(storage, publisher, nil, cfg, logger, outbox.WithWakeup(wakeup))
+
+	msg := &outbox.StorageRecord{
+		ID:            uuid.New(),
+		EventType:     "UserCreated",
+		AggregateType: "User",
+		AggregateID:   "123",
+		Data:          []byte(`{"name":"John"}`),
+		Topic:         "user.created",
+	}
+
+	published := make(chan struct{})
+	storage.On("FetchPendingMessages", mock.Anything, cfg.BatchSize).Return([]*outbox.StorageRecord{msg}, nil).Once()
+	publisher.On("Publish", msg).Return(nil).Once()
+	storage.On("MarkMessageSent", mock.Anything, msg.ID.String()).Return(nil).Once().
+		Run(func(mock.Arguments) { close(published) })
+
+	go func() {
+		wakeup <- struct{}{}
+		select {
+		case <-published:
+		case <-time.After(time.Second):
+		}
+		relay.ShutDown()
+	}()
+
+	err := relay.Start(ctx)
+	require.NoError(t, err)
+
+	storage.AssertExpectations(t)
+	publisher.AssertExpectations(t)
+}
+
+func TestRelay_Start_WithBatchStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := new(MockBatchStorage)
+	publisher := new(MockPublisher)
+
+	cfg := outbox.RelayConfig{
+		PollInterval: 10 * time.Millisecond,
+		BatchSize:    10,
+		MaxAttempts:  3,
+	}
+
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	relay := outbox.NewRelay(storage, publisher, nil, cfg, logger)
+
+	msgs := []*outbox.StorageRecord{
+		{ID: uuid.New(), EventType: "UserCreated", AggregateType: "User", AggregateID: "1", Topic: "users"},
+		{ID: uuid.New(), EventType: "UserCreated", AggregateType: "User", AggregateID: "2", Topic: "users"},
+		{ID: uuid.New(), EventType: "UserCreated", AggregateType: "User", AggregateID: "3", Topic: "users"},
+	}
+	publishErr := errors.New("nats: timeout")
+
+	// The successfully published messages are marked with one call, the failed one is retried.
+	storage.On("FetchPendingMessages", mock.Anything, cfg.BatchSize).Return(msgs, nil)
+	publisher.On("Publish", msgs[0]).Return(nil)
+	publisher.On("Publish", msgs[1]).Return(publishErr)
+	publisher.On("Publish", msgs[2]).Return(nil)
+	storage.On("IncrementAttempt", mock.Anything, msgs[1].ID.String(), time.Duration(0), publishErr.Error()).
+		Return(nil)
+	storage.On("MarkMessagesSent", mock.Anything, []string{msgs[0].ID.String(), msgs[2].ID.String()}).
+		Return(nil)
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		relay.ShutDown()
+	}()
+
+	err := relay.Start(ctx)
+	require.NoError(t, err)
+
+	storage.AssertExpectations(t)
+	storage.AssertNotCalled(t, "MarkMessageSent", mock.Anything, mock.Anything)
+	publisher.AssertExpectations(t)
+}
+
+func TestRelay_OrderingPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		orderingPolicy string
+		expectedCall   string
+		expectedError  bool
+	}{
+		{
+			name:         "#1 Default policy skips the dead message",
+			expectedCall: "MarkMessageDead",
+		},
+		{
+			name:           "#2 Block policy blocks the aggregate",
+			orderingPolicy: outbox.OrderingPolicyBlock,
+			expectedCall:   "MarkMessageBlocked",
+		},
+		{
+			name:           "#3 Dead letter policy marks the aggregate dead",
+			orderingPolicy: outbox.OrderingPolicyDeadLetter,
+			expectedCall:   "MarkAggregateDead",
+		},
+		{
+			name:           "#4 Unknown policy fails to start",
+			orderingPolicy: "ignore",
+			expectedError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storage := new(MockStorage)
+			publisher := new(MockPublisher)
+
+			cfg := outbox.RelayConfig{
+				PollInterval:   10 * time.Millisecond,
+				BatchSize:      10,
+				MaxAttempts:    3,
+				OrderingPolicy: tt.orderingPolicy,
+			}
+
+			logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+			relay := outbox.NewRelay(storage, publisher, nil, cfg, logger)
+
+			msg := &outbox.StorageRecord{
+				ID:            uuid.New(),
+				EventType:     "PaymentCaptured",
+				AggregateType: "Payment",
+				AggregateID:   "42",
+				Attempts:      3,
+				Topic:         "payments",
+			}
+			if !tt.expectedError {
+				storage.On("FetchPendingMessages", mock.Anything, cfg.BatchSize).
+					Return([]*outbox.StorageRecord{msg}, nil)
+				storage.On(tt.expectedCall, mock.Anything, msg.ID.String(), "exceeded max attempts (3)").Return(nil)
+			}
+
+			go func() {
+				time.Sleep(30 * time.Millisecond)
+				relay.ShutDown()
+			}()
+
+			err := relay.Start(ctx)
+			if tt.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			storage.AssertExpectations(t)
+			publisher.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBackoffConfig_Delay(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      outbox.BackoffConfig
+		attempts int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			name:     "#1 Zero value disables the backoff",
+			attempts: 3,
+		},
+		{
+			name:     "#2 First attempt waits the base delay",
+			cfg:      outbox.BackoffConfig{Base: time.Second, Multiplier: 2},
+			attempts: 1,
+			min:      time.Second,
+			max:      time.Second,
+		},
+		{
+			name:     "#3 Delay grows exponentially",
+			cfg:      outbox.BackoffConfig{Base: time.Second, Multiplier: 2},
+			attempts: 4,
+			min:      8 * time.Second,
+			max:      8 * time.Second,
+		},
+		{
+			name:     "#4 Delay is capped",
+			cfg:      outbox.BackoffConfig{Base: time.Second, Multiplier: 2, Max: 5 * time.Second},
+			attempts: 10,
+			min:      5 * time.Second,
+			max:      5 * time.Second,
+		},
+		{
+			name:     "#5 Jitter shortens the delay",
+			cfg:      outbox.BackoffConfig{Base: 10 * time.Second, Multiplier: 2, Jitter: 0.5},
+			attempts: 1,
+			min:      5 * time.Second,
+			max:      10 * time.Second,
+		},
+		{
+			name:     "#6 Uncapped delay does not overflow",
+			cfg:      outbox.BackoffConfig{Base: time.Second, Multiplier: 2},
+			attempts: 2000,
+			min:      time.Duration(math.MaxInt64) - time.Millisecond,
+			max:      time.Duration(math.MaxInt64),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay := tt.cfg.Delay(tt.attempts)
+			require.GreaterOrEqual(t, delay, tt.min)
+			require.LessOrEqual(t, delay, tt.max)
+		})
+	}
+}
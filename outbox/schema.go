@@ -0,0 +1,129 @@
+package outbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// SchemaVersionHeader is the header in which messages validated by a SchemaValidator carry the
+// version of their schema.
+const SchemaVersionHeader = "schema-version"
+
+// ErrInvalidEvent is returned when inserting a message whose data does not match the schema of its
+// event type, see Validate.
+var ErrInvalidEvent = errors.New("outbox event does not match its schema")
+
+type (
+	// SchemaValidator validates the data of messages against a JSON Schema per event type.
+	SchemaValidator struct {
+		schemas map[string]eventSchema
+	}
+
+	eventSchema struct {
+		schema  *jsonschema.Schema
+		version string
+	}
+)
+
+// NewSchemaValidator compiles the JSON Schemas in dir. Each schema is read from a file named after
+// the event type it validates, e.g. UserCreated.json, and may declare its version in a top-level
+// "version" keyword.
+func NewSchemaValidator(dir string) (*SchemaValidator, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schema files: %w", err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	v := &SchemaValidator{schemas: make(map[string]eventSchema, len(paths))}
+	for _, path := range paths {
+		eventType := strings.TrimSuffix(filepath.Base(path), ".json")
+
+		s, err := compileSchema(compiler, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile schema of %s events: %w", eventType, err)
+		}
+		v.schemas[eventType] = s
+	}
+
+	return v, nil
+}
+
+// compileSchema compiles the schema file at path.
+func compileSchema(compiler *jsonschema.Compiler, path string) (eventSchema, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return eventSchema{}, err
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(content))
+	if err != nil {
+		return eventSchema{}, err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return eventSchema{}, err
+	}
+	// The path is escaped, as the compiler would otherwise take a # in it for a fragment.
+	loc := (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+	if err = compiler.AddResource(loc, doc); err != nil {
+		return eventSchema{}, err
+	}
+
+	schema, err := compiler.Compile(loc)
+	if err != nil {
+		return eventSchema{}, err
+	}
+
+	var version string
+	if obj, ok := doc.(map[string]any); ok && obj["version"] != nil {
+		version = fmt.Sprint(obj["version"])
+	}
+
+	return eventSchema{schema: schema, version: version}, nil
+}
+
+// Validate checks the data of msg against the schema of its event type and records the version of
+// the schema in the schema-version header. Messages of event types without a schema are not
+// validated.
+func (v *SchemaValidator) Validate(msg *StorageRecord) error {
+	s, ok := v.schemas[msg.EventType]
+	if !ok {
+		return nil
+	}
+	if msg.KeyID != "" || msg.PayloadRef != "" {
+		return fmt.Errorf("cannot validate %s event which is encrypted or stored in a blob store", msg.EventType)
+	}
+
+	data, err := Decompress(msg.ContentEncoding, msg.Data)
+	if err != nil {
+		return err
+	}
+
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %s event is not JSON: %w", ErrInvalidEvent, msg.EventType, err)
+	}
+	if err = s.schema.Validate(instance); err != nil {
+		return fmt.Errorf("%w: %s event: %w", ErrInvalidEvent, msg.EventType, err)
+	}
+
+	if s.version != "" {
+		// The headers may be shared with the caller, so they are copied before being changed.
+		headers := make(Headers, len(msg.Headers)+1)
+		maps.Copy(headers, msg.Headers)
+		headers[SchemaVersionHeader] = s.version
+		msg.Headers = headers
+	}
+
+	return nil
+}
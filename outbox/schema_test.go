@@ -0,0 +1,178 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestSchemas writes schema files named after their event types and returns their directory.
+func writeTestSchemas(t *testing.T, schemas map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for eventType, schema := range schemas {
+		if err := os.WriteFile(filepath.Join(dir, eventType+".json"), []byte(schema), 0o600); err != nil {
+			t.Fatalf("failed to write schema: %v", err)
+		}
+	}
+
+	return dir
+}
+
+const userCreatedSchema = `{
+	"version": "2",
+	"type": "object",
+	"required": ["id", "email"],
+	"properties": {
+		"id": {"type": "integer"},
+		"email": {"type": "string", "format": "email"}
+	}
+}`
+
+func TestNewSchemaValidator(t *testing.T) {
+	tests := []struct {
+		name        string
+		schemas     map[string]string
+		expectError bool
+	}{
+		{
+			name:    "#1 Compiles the schemas",
+			schemas: map[string]string{"UserCreated": userCreatedSchema, "UserVerified": `{"type": "object"}`},
+		},
+		{
+			name:        "#2 Rejects invalid JSON",
+			schemas:     map[string]string{"UserCreated": `{"type": `},
+			expectError: true,
+		},
+		{
+			name:        "#3 Rejects invalid schemas",
+			schemas:     map[string]string{"UserCreated": `{"type": "record"}`},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSchemaValidator(writeTestSchemas(t, tt.schemas))
+			if (err != nil) != tt.expectError {
+				t.Errorf("unexpected error: got %v, want error=%v", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestSchemaValidator_Validate(t *testing.T) {
+	validator, err := NewSchemaValidator(writeTestSchemas(t, map[string]string{
+		"UserCreated":  userCreatedSchema,
+		"UserVerified": `{"type": "object"}`,
+	}))
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	compressed, err := compressData(ContentEncodingGzip, []byte(`{"id":1,"email":"john@example.com"}`))
+	if err != nil {
+		t.Fatalf("failed to compress data: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		msg             StorageRecord
+		expectedVersion string
+		expectInvalid   bool
+		expectError     bool
+	}{
+		{
+			name:            "#1 Accepts valid data and records the schema version",
+			msg:             StorageRecord{EventType: "UserCreated", Data: []byte(`{"id":1,"email":"john@example.com"}`)},
+			expectedVersion: "2",
+		},
+		{
+			name:          "#2 Rejects data which does not match the schema",
+			msg:           StorageRecord{EventType: "UserCreated", Data: []byte(`{"id":"1"}`)},
+			expectInvalid: true,
+			expectError:   true,
+		},
+		{
+			name:          "#3 Rejects data which is not JSON",
+			msg:           StorageRecord{EventType: "UserCreated", Data: []byte(`id=1`)},
+			expectInvalid: true,
+			expectError:   true,
+		},
+		{
+			name: "#4 Validates compressed data",
+			msg: StorageRecord{
+				EventType:       "UserCreated",
+				Data:            compressed,
+				ContentEncoding: ContentEncodingGzip,
+			},
+			expectedVersion: "2",
+		},
+		{
+			name: "#5 Skips event types without a schema",
+			msg:  StorageRecord{EventType: "UserDeleted", Data: []byte(`id=1`)},
+		},
+		{
+			name: "#6 Does not record a version if the schema has none",
+			msg:  StorageRecord{EventType: "UserVerified", Data: []byte(`{}`)},
+		},
+		{
+			name:        "#7 Cannot validate encrypted data",
+			msg:         StorageRecord{EventType: "UserCreated", Data: []byte(`{}`), KeyID: "2024-01"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.Validate(&tt.msg)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("unexpected error: got %v, want error=%v", err, tt.expectError)
+			}
+			if errors.Is(err, ErrInvalidEvent) != tt.expectInvalid {
+				t.Errorf("unexpected error: got %v, want ErrInvalidEvent=%v", err, tt.expectInvalid)
+			}
+			if got := tt.msg.Headers[SchemaVersionHeader]; got != tt.expectedVersion {
+				t.Errorf("unexpected schema version: got %q, want %q", got, tt.expectedVersion)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	validator, err := NewSchemaValidator(writeTestSchemas(t, map[string]string{"UserCreated": userCreatedSchema}))
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	storage := NewMemoryStorage()
+	headers := Headers{"tenant": "acme"}
+	msgs := []StorageRecord{
+		{EventType: "UserCreated", Data: []byte(`{"id":1,"email":"john@example.com"}`), Topic: "users", Headers: headers},
+		{EventType: "UserCreated", Data: []byte(`{"id":2}`), Topic: "users", Headers: headers},
+	}
+
+	// The invalid message fails the whole insert.
+	if _, err = storage.InsertMessages(ctx, msgs, Validate(validator), Compress(ContentEncodingGzip)); !errors.Is(err, ErrInvalidEvent) {
+		t.Fatalf("unexpected error: got %v, want ErrInvalidEvent", err)
+	}
+	if len(storage.Messages()) != 0 {
+		t.Fatalf("unexpected messages: got %d, want 0", len(storage.Messages()))
+	}
+
+	// The payload is validated before it is compressed.
+	if _, err = storage.InsertMessages(ctx, msgs[:1], Validate(validator), Compress(ContentEncodingGzip)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored := storage.Messages()[0]
+	if stored.Headers[SchemaVersionHeader] != "2" || stored.Headers["tenant"] != "acme" || stored.ContentEncoding != ContentEncodingGzip {
+		t.Errorf("unexpected message: got headers %v and content encoding %q", stored.Headers, stored.ContentEncoding)
+	}
+	if _, ok := headers[SchemaVersionHeader]; ok {
+		t.Error("expected the headers of the caller to be left unchanged")
+	}
+}
@@ -0,0 +1,476 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sqliteTimeLayout is the fixed-width text format of times stored by SQLiteStorage. All times are
+// stored in UTC, so they compare correctly as text, and the layout is understood by common drivers
+// when scanning DATETIME columns.
+const sqliteTimeLayout = "2006-01-02 15:04:05.000000000-07:00"
+
+// sqliteMigrations are the schema migrations of SQLiteStorage, see migration.
+var sqliteMigrations = []migration{
+	{
+		version:     1,
+		description: "create outbox table",
+		query: `
+		CREATE TABLE IF NOT EXISTS {table} (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			id TEXT NOT NULL UNIQUE,
+			event_type TEXT NOT NULL,
+			aggregate_type TEXT NOT NULL,
+			aggregate_id TEXT NOT NULL,
+			data BLOB NOT NULL,
+			created_at DATETIME NOT NULL,
+			sent_at DATETIME,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			topic TEXT NOT NULL,
+			dedup_key TEXT,
+			headers TEXT NOT NULL DEFAULT '{}',
+			available_at DATETIME,
+			next_attempt_at DATETIME,
+			last_error TEXT,
+			last_error_at DATETIME,
+			priority INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_{table_name}_aggregate
+		ON {table} (aggregate_type, aggregate_id, status, seq);
+
+		CREATE INDEX IF NOT EXISTS idx_{table_name}_status_sent_at
+		ON {table} (status, sent_at);
+		`,
+	},
+	{
+		version:     2,
+		description: "add content encoding",
+		query: `
+		ALTER TABLE {table} ADD COLUMN content_encoding TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     3,
+		description: "add encryption key ID",
+		query: `
+		ALTER TABLE {table} ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     4,
+		description: "add payload reference",
+		query: `
+		ALTER TABLE {table} ADD COLUMN payload_ref TEXT NOT NULL DEFAULT '';
+		`,
+	},
+}
+
+// SQLiteStorage provides DB operations for the outbox pattern on SQLite 3.25 or later, e.g. for
+// embedded agents which forward their events once connectivity returns.
+//
+// It stores the same records as SQLStorage and preserves the order of messages within an aggregate,
+// but supports neither row claiming, archiving nor partitioning. SQLite allows a single writer at a
+// time, so the connection should set a busy timeout (e.g. _pragma=busy_timeout(5000) with
+// modernc.org/sqlite). To run several relays on one database file, use a FileLockElector.
+type SQLiteStorage struct {
+	db        *sql.DB
+	tableName string
+	// replacer expands the table placeholders used in queries, see SQLiteStorage.sql.
+	replacer *strings.Replacer
+}
+
+// SQLiteStorageOption configures an SQLiteStorage.
+type SQLiteStorageOption func(*SQLiteStorage) error
+
+// WithSQLiteTableName overrides the outbox table name, which defaults to DefaultTableName.
+func WithSQLiteTableName(name string) SQLiteStorageOption {
+	return func(s *SQLiteStorage) error {
+		if name == "" {
+			return nil
+		}
+		if !identifierPattern.MatchString(name) || len(name) > maxTableNameLength {
+			return fmt.Errorf("invalid outbox table name %q", name)
+		}
+		s.tableName = name
+
+		return nil
+	}
+}
+
+// NewSQLiteStorage creates a new SQLiteStorage instance.
+func NewSQLiteStorage(db *sql.DB, opts ...SQLiteStorageOption) (*SQLiteStorage, error) {
+	s := &SQLiteStorage{
+		db:        db,
+		tableName: DefaultTableName,
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	s.replacer = strings.NewReplacer(
+		"{table}", quoteIdentifier("", s.tableName),
+		"{table_name}", s.tableName,
+		"{migrations_table}", quoteIdentifier("", s.tableName+"_schema_migrations"),
+	)
+
+	return s, nil
+}
+
+// sql expands the placeholders of a query template like SQLStorage.sql.
+func (s *SQLiteStorage) sql(query string) string {
+	return s.replacer.Replace(query)
+}
+
+// sqliteTime formats t for storage, see sqliteTimeLayout.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+// sqliteNullTime is like sqliteTime, but maps nil to NULL.
+func sqliteNullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+
+	return sqliteTime(*t)
+}
+
+// Migrate brings the outbox schema up to date by applying all pending migrations in order, in one
+// transaction.
+func (s *SQLiteStorage) Migrate(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	const createMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS {migrations_table} (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	);
+	`
+	if _, err = tx.ExecContext(ctx, s.sql(createMigrationsTable)); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current int
+	if err = tx.QueryRowContext(ctx,
+		s.sql("SELECT COALESCE(MAX(version), 0) FROM {migrations_table}"),
+	).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range sqliteMigrations {
+		if m.version <= current {
+			continue
+		}
+
+		if _, err = tx.ExecContext(ctx, s.sql(m.query)); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.description, err)
+		}
+
+		if _, err = tx.ExecContext(ctx,
+			s.sql("INSERT INTO {migrations_table} (version, description, applied_at) VALUES (?, ?, ?)"),
+			m.version, m.description, sqliteTime(time.Now()),
+		); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migrations: %w", err)
+	}
+
+	return nil
+}
+
+// InsertMessage inserts a new message into the outbox table and returns its ID.
+func (s *SQLiteStorage) InsertMessage(ctx context.Context, tx Executor, msg StorageRecord, opts ...InsertOption) (uuid.UUID, error) {
+	ids, err := s.InsertMessages(ctx, tx, []StorageRecord{msg}, opts...)
+	if len(ids) == 0 {
+		return uuid.Nil, err
+	}
+
+	return ids[0], err
+}
+
+// InsertMessages inserts several messages into the outbox table, like SQLStorage.InsertMessages.
+// Messages are inserted one by one, as SQLite limits the number of bind parameters per statement.
+func (s *SQLiteStorage) InsertMessages(ctx context.Context, tx Executor, msgs []StorageRecord, opts ...InsertOption) ([]uuid.UUID, error) {
+	var o insertOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	query := `
+		INSERT INTO {table} (id, event_type, aggregate_type, aggregate_id, data, topic, status, dedup_key, headers,
+			available_at, priority, content_encoding, key_id, payload_ref, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	if o.ignoreDuplicates {
+		query += " ON CONFLICT (id) DO NOTHING"
+	}
+
+	prepared, err := o.apply(ctx, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	query = s.sql(query)
+	createdAt := sqliteTime(time.Now())
+	ids := make([]uuid.UUID, 0, len(prepared))
+	duplicates := false
+	for _, msg := range prepared {
+		ids = append(ids, msg.ID)
+
+		result, err := tx.ExecContext(ctx, query,
+			msg.ID.String(),
+			msg.EventType,
+			msg.AggregateType,
+			msg.AggregateID,
+			msg.Data,
+			msg.Topic,
+			RecordStatusPending,
+			nullString(msg.DeduplicationKey),
+			msg.Headers,
+			sqliteNullTime(msg.AvailableAt),
+			msg.Priority,
+			msg.ContentEncoding,
+			msg.KeyID,
+			msg.PayloadRef,
+			createdAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert outbox messages: %w", err)
+		}
+
+		if o.ignoreDuplicates {
+			rowsAffected, err := result.RowsAffected()
+			if err != nil {
+				return nil, fmt.Errorf("failed to check affected rows: %w", err)
+			}
+			if rowsAffected == 0 {
+				duplicates = true
+			}
+		}
+	}
+
+	if duplicates {
+		return ids, ErrMessageExists
+	}
+
+	return ids, nil
+}
+
+// FetchPendingMessages retrieves pending messages like SQLStorage.FetchPendingMessages, using window
+// functions instead of DISTINCT ON to find the oldest message of each aggregate.
+func (s *SQLiteStorage) FetchPendingMessages(ctx context.Context, batchSize int) ([]*StorageRecord, error) {
+	const query = `
+		SELECT ` + recordColumns + `
+		FROM (
+			SELECT ` + recordColumns + `, seq,
+				ROW_NUMBER() OVER (PARTITION BY aggregate_type, aggregate_id ORDER BY seq) AS aggregate_position,
+				MAX(priority) OVER (PARTITION BY aggregate_type, aggregate_id) AS aggregate_priority
+			FROM {table}
+			WHERE status IN (?, ?)
+		) AS next_events
+		WHERE aggregate_position = 1
+			AND status = ?
+			AND (available_at IS NULL OR available_at <= ?)
+			AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+		ORDER BY aggregate_priority DESC, seq ASC
+		LIMIT ?
+	`
+
+	now := sqliteTime(time.Now())
+	rows, err := s.db.QueryContext(ctx, s.sql(query),
+		RecordStatusPending, RecordStatusBlocked, RecordStatusPending, now, now, batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending messages: %w", err)
+	}
+
+	return scanRecords(rows)
+}
+
+// MarkMessageSent marks a message as successfully sent.
+func (s *SQLiteStorage) MarkMessageSent(ctx context.Context, id string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?, sent_at = ?
+		WHERE id = ?
+	`
+
+	result, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusSent, sqliteTime(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("failed to update message status to sent: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("no rows updated")
+	}
+	return nil
+}
+
+// IncrementAttempt increments the attempt count for a message, records the reason of the failed
+// attempt and defers the next attempt by retryAfter.
+func (s *SQLiteStorage) IncrementAttempt(ctx context.Context, id string, retryAfter time.Duration, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET attempts = attempts + 1,
+			next_attempt_at = ?,
+			last_error = ?,
+			last_error_at = ?
+		WHERE id = ?
+	`
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, s.sql(query),
+		sqliteTime(now.Add(retryAfter)), reason, sqliteTime(now), id,
+	); err != nil {
+		return fmt.Errorf("failed to increment attempt count: %w", err)
+	}
+	return nil
+}
+
+// MarkMessageDead marks a message as dead (failed permanently), see SQLStorage.MarkMessageDead.
+func (s *SQLiteStorage) MarkMessageDead(ctx context.Context, id string, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?,
+			sent_at = ?,
+			last_error = CASE WHEN last_error IS NULL THEN ? ELSE ? || ': ' || last_error END,
+			last_error_at = ?
+		WHERE id = ?
+	`
+	now := sqliteTime(time.Now())
+	if _, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusDead, now, reason, reason, now, id); err != nil {
+		return fmt.Errorf("failed to mark message as dead: %w", err)
+	}
+	return nil
+}
+
+// MarkMessageBlocked marks a failed message as blocked, see SQLStorage.MarkMessageBlocked.
+func (s *SQLiteStorage) MarkMessageBlocked(ctx context.Context, id string, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?,
+			last_error = CASE WHEN last_error IS NULL THEN ? ELSE ? || ': ' || last_error END,
+			last_error_at = ?
+		WHERE id = ?
+	`
+	if _, err := s.db.ExecContext(ctx, s.sql(query),
+		RecordStatusBlocked, reason, reason, sqliteTime(time.Now()), id,
+	); err != nil {
+		return fmt.Errorf("failed to mark message as blocked: %w", err)
+	}
+	return nil
+}
+
+// MarkAggregateDead marks a message as dead together with all later pending messages of its aggregate,
+// see SQLStorage.MarkAggregateDead.
+func (s *SQLiteStorage) MarkAggregateDead(ctx context.Context, id string, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?,
+			sent_at = ?,
+			last_error = CASE
+				WHEN id = ? THEN CASE WHEN last_error IS NULL THEN ? ELSE ? || ': ' || last_error END
+				ELSE ?
+			END,
+			last_error_at = ?
+		WHERE status = ?
+			AND (aggregate_type, aggregate_id) = (SELECT aggregate_type, aggregate_id FROM {table} WHERE id = ?)
+	`
+
+	now := sqliteTime(time.Now())
+	followerReason := fmt.Sprintf("preceding message %s is dead", id)
+	if _, err := s.db.ExecContext(ctx, s.sql(query),
+		RecordStatusDead, now, id, reason, reason, followerReason, now, RecordStatusPending, id,
+	); err != nil {
+		return fmt.Errorf("failed to mark aggregate as dead: %w", err)
+	}
+	return nil
+}
+
+// FetchBlockedMessages retrieves up to limit blocked messages, oldest first.
+func (s *SQLiteStorage) FetchBlockedMessages(ctx context.Context, limit int) ([]*StorageRecord, error) {
+	const query = `
+		SELECT ` + recordColumns + `
+		FROM {table}
+		WHERE status = ?
+		ORDER BY seq ASC
+		LIMIT ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, s.sql(query), RecordStatusBlocked, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blocked messages: %w", err)
+	}
+
+	return scanRecords(rows)
+}
+
+// RequeueMessage makes a blocked message pending again, see SQLStorage.RequeueMessage.
+func (s *SQLiteStorage) RequeueMessage(ctx context.Context, id string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?, attempts = 0, next_attempt_at = NULL
+		WHERE id = ? AND status = ?
+	`
+	result, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusPending, id, RecordStatusBlocked)
+	if err != nil {
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SkipBlockedMessage marks a blocked message as dead, see SQLStorage.SkipBlockedMessage.
+func (s *SQLiteStorage) SkipBlockedMessage(ctx context.Context, id string, reason string) error {
+	const query = `
+		UPDATE {table}
+		SET status = ?,
+			sent_at = ?,
+			last_error = CASE WHEN last_error IS NULL THEN ? ELSE ? || ': ' || last_error END,
+			last_error_at = ?
+		WHERE id = ? AND status = ?
+	`
+	now := sqliteTime(time.Now())
+	result, err := s.db.ExecContext(ctx, s.sql(query),
+		RecordStatusDead, now, reason, reason, now, id, RecordStatusBlocked,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to skip blocked message: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+// newTestSQLiteStorage returns a migrated SQLiteStorage backed by a fresh database file.
+func newTestSQLiteStorage(t *testing.T) (*SQLiteStorage, *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	storage, err := NewSQLiteStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err = storage.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	return storage, db
+}
+
+// insertTestMessages inserts msgs in one transaction and returns their IDs.
+func insertTestMessages(t *testing.T, storage *SQLiteStorage, db *sql.DB, msgs ...StorageRecord) []uuid.UUID {
+	t.Helper()
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	ids, err := storage.InsertMessages(ctx, tx, msgs)
+	if err != nil {
+		t.Fatalf("failed to insert messages: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	return ids
+}
+
+// fetchTestIDs returns the IDs of the currently fetchable messages, in fetch order.
+func fetchTestIDs(t *testing.T, storage *SQLiteStorage) []uuid.UUID {
+	t.Helper()
+
+	records, err := storage.FetchPendingMessages(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("failed to fetch messages: %v", err)
+	}
+
+	ids := make([]uuid.UUID, 0, len(records))
+	for _, rec := range records {
+		ids = append(ids, rec.ID)
+	}
+
+	return ids
+}
+
+func assertTestIDs(t *testing.T, got []uuid.UUID, want ...uuid.UUID) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("unexpected messages: got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected messages: got %v, want %v", got, want)
+		}
+	}
+}
+
+func testMessage(aggregateID, eventType string) StorageRecord {
+	return StorageRecord{
+		EventType:     eventType,
+		AggregateType: "Order",
+		AggregateID:   aggregateID,
+		Data:          []byte(`{}`),
+		Topic:         "orders",
+	}
+}
+
+func TestSQLiteStorage_Migrate(t *testing.T) {
+	storage, _ := newTestSQLiteStorage(t)
+
+	// Migrating again is a no-op.
+	if err := storage.Migrate(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSQLiteStorage_InsertMessage(t *testing.T) {
+	storage, db := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	t.Run("#1 Rolled back messages are not stored", func(t *testing.T) {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("failed to begin transaction: %v", err)
+		}
+		if _, err = storage.InsertMessage(ctx, tx, testMessage("1", "OrderPlaced")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = tx.Rollback(); err != nil {
+			t.Fatalf("failed to roll back: %v", err)
+		}
+
+		assertTestIDs(t, fetchTestIDs(t, storage))
+	})
+
+	t.Run("#2 Stores all fields", func(t *testing.T) {
+		msg := testMessage("2", "OrderPlaced")
+		msg.Headers = Headers{"tenant": "acme"}
+		msg.Priority = 3
+		ids := insertTestMessages(t, storage, db, msg)
+
+		records, err := storage.FetchPendingMessages(ctx, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("unexpected number of records: got %d, want 1", len(records))
+		}
+		rec := records[0]
+		if rec.ID != ids[0] || rec.Status != RecordStatusPending || rec.Headers["tenant"] != "acme" ||
+			rec.Priority != 3 || string(rec.Data) != `{}` || time.Since(rec.CreatedAt) > time.Minute {
+			t.Errorf("unexpected record: %+v", rec)
+		}
+	})
+
+	t.Run("#3 Reports duplicates in IgnoreDuplicates mode", func(t *testing.T) {
+		msg := testMessage("3", "OrderPlaced")
+		msg.DeduplicationKey = "order-3-placed"
+		first := insertTestMessages(t, storage, db, msg)
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("failed to begin transaction: %v", err)
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		id, err := storage.InsertMessage(ctx, tx, msg, IgnoreDuplicates())
+		if !errors.Is(err, ErrMessageExists) {
+			t.Errorf("unexpected error: got %v, want %v", err, ErrMessageExists)
+		}
+		if id != first[0] {
+			t.Errorf("unexpected id: got %v, want %v", id, first[0])
+		}
+	})
+}
+
+func TestSQLiteStorage_FetchPendingMessages(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("#1 Keeps the order within an aggregate", func(t *testing.T) {
+		storage, db := newTestSQLiteStorage(t)
+		ids := insertTestMessages(t, storage, db,
+			testMessage("1", "OrderPlaced"),
+			testMessage("2", "OrderPlaced"),
+			testMessage("1", "OrderShipped"),
+		)
+
+		assertTestIDs(t, fetchTestIDs(t, storage), ids[0], ids[1])
+
+		if err := storage.MarkMessageSent(ctx, ids[0].String()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertTestIDs(t, fetchTestIDs(t, storage), ids[1], ids[2])
+	})
+
+	t.Run("#2 Prefers aggregates with a higher priority", func(t *testing.T) {
+		storage, db := newTestSQLiteStorage(t)
+		urgent := testMessage("1", "OrderPaid")
+		urgent.Priority = 10
+		ids := insertTestMessages(t, storage, db,
+			testMessage("1", "OrderPlaced"),
+			testMessage("2", "OrderPlaced"),
+			urgent,
+		)
+
+		// The urgent message raises its aggregate, but does not overtake the older message.
+		assertTestIDs(t, fetchTestIDs(t, storage), ids[0], ids[1])
+	})
+
+	t.Run("#3 Holds back scheduled and retried messages", func(t *testing.T) {
+		storage, db := newTestSQLiteStorage(t)
+		later := time.Now().Add(time.Hour)
+		scheduled := testMessage("1", "ReminderDue")
+		scheduled.AvailableAt = &later
+		ids := insertTestMessages(t, storage, db,
+			scheduled,
+			testMessage("1", "OrderPlaced"),
+			testMessage("2", "OrderPlaced"),
+		)
+
+		assertTestIDs(t, fetchTestIDs(t, storage), ids[2])
+
+		if err := storage.IncrementAttempt(ctx, ids[2].String(), time.Hour, "publish failed"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertTestIDs(t, fetchTestIDs(t, storage))
+
+		if err := storage.IncrementAttempt(ctx, ids[2].String(), 0, "publish failed"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		records, err := storage.FetchPendingMessages(ctx, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(records) != 1 || records[0].Attempts != 2 || *records[0].LastError != "publish failed" {
+			t.Errorf("unexpected records: %+v", records)
+		}
+	})
+}
+
+func TestSQLiteStorage_OrderingPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("#1 A dead message releases its aggregate", func(t *testing.T) {
+		storage, db := newTestSQLiteStorage(t)
+		ids := insertTestMessages(t, storage, db, testMessage("1", "OrderPlaced"), testMessage("1", "OrderShipped"))
+
+		if err := storage.MarkMessageDead(ctx, ids[0].String(), "exceeded max attempts (3)"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertTestIDs(t, fetchTestIDs(t, storage), ids[1])
+	})
+
+	t.Run("#2 A blocked message holds back its aggregate until requeued", func(t *testing.T) {
+		storage, db := newTestSQLiteStorage(t)
+		ids := insertTestMessages(t, storage, db,
+			testMessage("1", "OrderPlaced"),
+			testMessage("1", "OrderShipped"),
+			testMessage("2", "OrderPlaced"),
+		)
+
+		if err := storage.MarkMessageBlocked(ctx, ids[0].String(), "exceeded max attempts (3)"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertTestIDs(t, fetchTestIDs(t, storage), ids[2])
+
+		blocked, err := storage.FetchBlockedMessages(ctx, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(blocked) != 1 || blocked[0].ID != ids[0] {
+			t.Errorf("unexpected blocked messages: %+v", blocked)
+		}
+
+		if err = storage.RequeueMessage(ctx, ids[0].String()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertTestIDs(t, fetchTestIDs(t, storage), ids[0], ids[2])
+	})
+
+	t.Run("#3 Dead lettering marks the rest of the aggregate dead", func(t *testing.T) {
+		storage, db := newTestSQLiteStorage(t)
+		ids := insertTestMessages(t, storage, db,
+			testMessage("1", "OrderPlaced"),
+			testMessage("1", "OrderShipped"),
+			testMessage("2", "OrderPlaced"),
+		)
+
+		if err := storage.MarkAggregateDead(ctx, ids[0].String(), "exceeded max attempts (3)"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertTestIDs(t, fetchTestIDs(t, storage), ids[2])
+	})
+}
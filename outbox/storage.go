@@ -3,8 +3,13 @@ package outbox
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,138 +19,735 @@ const (
 	RecordStatusPending = "pending"
 	RecordStatusSent    = "sent"
 	RecordStatusDead    = "dead"
+	// RecordStatusBlocked marks a failed message which holds back its aggregate until an operator
+	// requeues or skips it, see OrderingPolicyBlock.
+	RecordStatusBlocked = "blocked"
 )
 
+// ErrMessageExists is returned by InsertMessage in IgnoreDuplicates mode when a message
+// with the same ID is already stored. The surrounding transaction stays usable.
+var ErrMessageExists = errors.New("outbox message already exists")
+
+// ErrNotFound is returned by RequeueMessage and SkipBlockedMessage when there is no blocked message
+// with the given ID.
+var ErrNotFound = errors.New("no blocked outbox message found")
+
+// deduplicationNamespace is the UUID namespace used to derive message IDs from deduplication keys.
+var deduplicationNamespace = uuid.MustParse("6f1d9a3e-2c47-4b8e-9a51-0d3c7e8b4f12")
+
 // StorageRecord represents a message stored in the outbox table.
+//
+// DeduplicationKey is an optional caller-defined key. When ID is not set, the ID is derived
+// from it deterministically, so inserting the same key twice yields the same message.
+//
+// AvailableAt is the earliest time the message may be published; nil means immediately.
+// NextAttemptAt is set by the relay after a failed publish to back off from retrying too early.
+// LastError and LastErrorAt describe the latest failure, e.g. why the message ended up dead.
+//
+// Priority lets aggregates with more important messages be published first (higher values first,
+// 0 by default). Messages of one aggregate are still published in insertion order: a message raises
+// the priority of the whole backlog of its aggregate instead of overtaking older messages.
+//
+// ContentEncoding names the compression of Data (see Compress), empty if it is not compressed. KeyID
+// names the key of the keyring which encrypted Data (see Encrypt), empty if it is not encrypted.
+// PayloadRef references the payload in a BlobStore when it was too large to be stored in Data (see
+// ClaimCheck), in which case Data is empty.
 type StorageRecord struct {
-	ID            uuid.UUID  `db:"id"`
-	EventType     string     `db:"event_type"`
-	AggregateType string     `db:"aggregate_type"`
-	AggregateID   string     `db:"aggregate_id"`
-	Data          []byte     `db:"data"`
-	CreatedAt     time.Time  `db:"created_at"`
-	SentAt        *time.Time `db:"sent_at"`
-	Status        string     `db:"status"`
-	Attempts      int        `db:"attempts"`
-	Topic         string     `db:"topic"`
+	ID               uuid.UUID  `db:"id"`
+	EventType        string     `db:"event_type"`
+	AggregateType    string     `db:"aggregate_type"`
+	AggregateID      string     `db:"aggregate_id"`
+	Data             []byte     `db:"data"`
+	CreatedAt        time.Time  `db:"created_at"`
+	SentAt           *time.Time `db:"sent_at"`
+	Status           string     `db:"status"`
+	Attempts         int        `db:"attempts"`
+	Topic            string     `db:"topic"`
+	DeduplicationKey string     `db:"dedup_key"`
+	Headers          Headers    `db:"headers"`
+	AvailableAt      *time.Time `db:"available_at"`
+	NextAttemptAt    *time.Time `db:"next_attempt_at"`
+	LastError        *string    `db:"last_error"`
+	LastErrorAt      *time.Time `db:"last_error_at"`
+	Priority         int        `db:"priority"`
+	ContentEncoding  string     `db:"content_encoding"`
+	KeyID            string     `db:"key_id"`
+	PayloadRef       string     `db:"payload_ref"`
+}
+
+// Headers holds arbitrary metadata of a message (e.g. tenant, schema version or content type).
+// They are persisted as a JSON object and published verbatim along with the message.
+type Headers map[string]string
+
+// Value implements driver.Valuer, encoding the headers as a JSON object.
+func (h Headers) Value() (driver.Value, error) {
+	if h == nil {
+		return "{}", nil
+	}
+
+	b, err := json.Marshal(map[string]string(h))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner, decoding the headers from a JSON object.
+func (h *Headers) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unsupported headers type %T", src)
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("failed to decode headers: %w", err)
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	*h = m
+
+	return nil
 }
 
 // SQLStorage provides DB operations for the outbox pattern.
 type SQLStorage struct {
-	db *sql.DB
+	db        *sql.DB
+	schema    string
+	tableName string
+	// replacer expands the table placeholders used in queries, see SQLStorage.sql.
+	replacer *strings.Replacer
+	// lockID is the advisory lock that serializes migrations of this outbox table.
+	lockID int64
+	// claimOwner and claimLease are set in row claiming mode, see WithRowClaiming.
+	claimOwner string
+	claimLease time.Duration
+	// archive moves messages to the archive table once they are sent or dead, see WithArchiving.
+	archive bool
+	// partitioned creates the outbox table partitioned by created_at, see WithPartitioning.
+	partitioned bool
+	// notifyChannel is notified of inserted messages, see WithNotifyChannel.
+	notifyChannel string
 }
 
 // NewSQLStorage creates a new SQLStorage instance.
-func NewSQLStorage(db *sql.DB) *SQLStorage {
-	return &SQLStorage{
-		db: db,
+func NewSQLStorage(db *sql.DB, opts ...SQLStorageOption) (*SQLStorage, error) {
+	s := &SQLStorage{
+		db:        db,
+		tableName: DefaultTableName,
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	table := quoteIdentifier(s.schema, s.tableName)
+	var schemaPrefix string
+	if s.schema != "" {
+		schemaPrefix = quoteIdentifier("", s.schema) + "."
 	}
+	s.replacer = strings.NewReplacer(
+		"{table}", table,
+		"{table_name}", s.tableName,
+		"{migrations_table}", quoteIdentifier(s.schema, s.tableName+"_schema_migrations"),
+		"{archive_table}", quoteIdentifier(s.schema, s.tableName+"_archive"),
+		"{default_partition}", quoteIdentifier(s.schema, s.tableName+"_default"),
+		"{schema_prefix}", schemaPrefix,
+	)
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(table))
+	s.lockID = int64(h.Sum64())
+
+	return s, nil
+}
+
+// sql expands the placeholders of a query template: {table} is the quoted, schema-qualified
+// outbox table, {table_name} its bare name (used to prefix index names), while {migrations_table}
+// and {archive_table} are the quoted, schema-qualified migrations tracking and archive tables.
+// {default_partition} is the partition catching rows outside of all daily partitions, and
+// {schema_prefix} qualifies other tables with the schema ("schema". or nothing).
+func (s *SQLStorage) sql(query string) string {
+	return s.replacer.Replace(query)
 }
 
 // InitOutboxTable creates the outbox table if it doesn't exist yet.
+//
+// Deprecated: use Migrate, which also upgrades existing tables.
 func (s *SQLStorage) InitOutboxTable(ctx context.Context) error {
-	const query = `
-	CREATE TABLE IF NOT EXISTS outbox (
-		id UUID PRIMARY KEY,
-		event_type TEXT NOT NULL,
-		aggregate_type TEXT NOT NULL,
-		aggregate_id TEXT NOT NULL,
-		data BYTEA NOT NULL,
-		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
-		sent_at TIMESTAMPTZ,
-		status TEXT NOT NULL,
-		attempts INT NOT NULL DEFAULT 0,
-		topic TEXT NOT NULL
-	);
-	
-	CREATE INDEX IF NOT EXISTS idx_outbox_aggregate_type_id
-	ON outbox (aggregate_type, aggregate_id);
-	`
+	return s.Migrate(ctx)
+}
+
+type (
+	// Executor runs the statements of InsertMessage, normally within the caller's transaction. It is
+	// satisfied by *sql.Tx and *sql.Conn (e.g. inside a savepoint), by *sqlx.Tx, and by the ConnPool of
+	// a GORM transaction (tx.Statement.ConnPool). Other types can be adapted with ExecutorFunc.
+	//
+	// An *sql.DB is an Executor as well, but the message is then stored independently of any business
+	// data, which defeats the purpose of the outbox.
+	Executor interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	}
+
+	// ExecutorFunc adapts a function to an Executor.
+	ExecutorFunc func(ctx context.Context, query string, args ...any) (sql.Result, error)
+
+	// InsertOption customizes a single InsertMessage call.
+	InsertOption func(*insertOptions)
+
+	insertOptions struct {
+		ignoreDuplicates bool
+		availableAt      *time.Time
+		delay            time.Duration
+		compression      string
+		keyring          Keyring
+		blobStore        BlobStore
+		blobThreshold    int
+		validator        *SchemaValidator
+	}
+)
+
+// ExecContext calls f.
+func (f ExecutorFunc) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return f(ctx, query, args...)
+}
+
+// apply returns copies of msgs which are ready to be stored: their IDs are set, the delivery time is
+// set for messages which do not define their own AvailableAt, and the data of messages which are not
+// encoded yet is compressed and either encrypted or offloaded. All messages are validated before any
+// of them is encoded, so an invalid message does not leave the offloaded payloads of others behind.
+func (o insertOptions) apply(ctx context.Context, msgs []StorageRecord) ([]StorageRecord, error) {
+	// Only the relay decrypts messages, so offloaded payloads would have to be stored in plaintext.
+	if o.blobStore != nil && o.keyring != nil {
+		return nil, errors.New("claim-check cannot be combined with encryption")
+	}
+
+	prepared := make([]StorageRecord, 0, len(msgs))
+	for _, msg := range msgs {
+		msg.ID = msg.MessageID()
+
+		if msg.AvailableAt == nil {
+			switch {
+			case o.availableAt != nil:
+				msg.AvailableAt = o.availableAt
+			case o.delay > 0:
+				availableAt := time.Now().Add(o.delay)
+				msg.AvailableAt = &availableAt
+			}
+		}
 
-	if _, err := s.db.ExecContext(ctx, query); err != nil {
-		return fmt.Errorf("failed to initialize outbox table and indexes: %w", err)
+		if o.validator != nil {
+			if err := o.validator.Validate(&msg); err != nil {
+				return nil, err
+			}
+		}
+
+		prepared = append(prepared, msg)
+	}
+
+	for i := range prepared {
+		if err := o.encode(ctx, &prepared[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return prepared, nil
+}
+
+// encode compresses and either encrypts or offloads the data of msg, unless it is encoded already.
+func (o insertOptions) encode(ctx context.Context, msg *StorageRecord) error {
+	if o.compression != "" && msg.ContentEncoding == "" {
+		data, err := compressData(o.compression, msg.Data)
+		if err != nil {
+			return err
+		}
+		msg.Data = data
+		msg.ContentEncoding = o.compression
+	}
+
+	if o.blobStore != nil && msg.PayloadRef == "" && len(msg.Data) > o.blobThreshold {
+		// A message inserted again with the same ID (e.g. with a reused deduplication key) gets a blob
+		// of its own, so the payload of the stored, and maybe already published, message is kept.
+		ref, err := o.blobStore.Put(ctx, msg.ID.String()+"."+uuid.NewString(), msg.Data)
+		if err != nil {
+			return fmt.Errorf("failed to store message payload: %w", err)
+		}
+		msg.Data = []byte{}
+		msg.PayloadRef = ref
+	}
+
+	// Compressing encrypted data would not save anything, so it is encrypted last.
+	if o.keyring != nil && msg.KeyID == "" && msg.PayloadRef == "" {
+		keyID, data, err := encryptData(o.keyring, msg.ID, msg.Data)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		msg.Data = data
+		msg.KeyID = keyID
 	}
 
 	return nil
 }
 
-// InsertMessage inserts a new message into the outbox table.
-func (s *SQLStorage) InsertMessage(ctx context.Context, tx *sql.Tx, msg StorageRecord) error {
-	query := `
-        INSERT INTO outbox (id, event_type, aggregate_type, aggregate_id, data, topic, created_at, status, attempts)
-        VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7, 0)
-    `
+// IgnoreDuplicates makes InsertMessage skip messages whose ID already exists instead of failing.
+// In that case ErrMessageExists is returned together with the ID, and the transaction is not aborted.
+//
+// Only messages in the outbox table are detected, not archived ones (see WithArchiving and
+// CleanupModeArchive). It is not supported on partitioned outbox tables, whose primary key
+// includes created_at.
+func IgnoreDuplicates() InsertOption {
+	return func(o *insertOptions) {
+		o.ignoreDuplicates = true
+	}
+}
+
+// DeliverAt schedules the inserted messages to not be published before t.
+//
+// A scheduled message holds back all later messages of its aggregate until it is published.
+func DeliverAt(t time.Time) InsertOption {
+	return func(o *insertOptions) {
+		o.availableAt = &t
+	}
+}
+
+// DeliverAfter schedules the inserted messages to not be published before the given delay elapsed.
+//
+// A scheduled message holds back all later messages of its aggregate until it is published.
+func DeliverAfter(d time.Duration) InsertOption {
+	return func(o *insertOptions) {
+		o.delay = d
+	}
+}
+
+// Compress compresses the data of the inserted messages with the given content encoding, either
+// ContentEncodingGzip or ContentEncodingZstd, and records it in their ContentEncoding. Messages which
+// already have a ContentEncoding are stored as is.
+func Compress(encoding string) InsertOption {
+	return func(o *insertOptions) {
+		o.compression = encoding
+	}
+}
+
+// Encrypt encrypts the data of the inserted messages with a new data key each, which is wrapped by the
+// current key of the keyring, and records the key in their KeyID. Messages which already have a
+// KeyID are stored as is. The relay decrypts the messages before publishing them, see WithDecryption.
+func Encrypt(keyring Keyring) InsertOption {
+	return func(o *insertOptions) {
+		o.keyring = keyring
+	}
+}
+
+// ClaimCheck stores the data of inserted messages larger than threshold bytes in the blob store
+// instead of the outbox table, and records the reference in their PayloadRef. The relay publishes
+// such messages with a payload-ref header instead of the data, which consumers resolve with
+// ResolveNatsPayload. Data is stored after compression. The insert fails when combined with
+// Encrypt, as the payloads would be stored in plaintext, so the blob store must be protected by itself.
+//
+// Stored payloads are never deleted by the outbox, not even by the Cleaner, as it cannot tell when
+// consumers resolved them. Expire them in the store once all consumers are done with them. Payloads
+// are stored before the messages, so failed or rolled back inserts leave unreferenced payloads behind,
+// which the expiry has to cover as well.
+func ClaimCheck(store BlobStore, threshold int) InsertOption {
+	return func(o *insertOptions) {
+		o.blobStore = store
+		o.blobThreshold = threshold
+	}
+}
+
+// Validate fails the insert with ErrInvalidEvent if the data of a message does not match the JSON
+// Schema of its event type, without storing any of the messages. The transaction is left as is, so
+// rolling it back is up to the caller. The version of the schema is recorded in the schema-version
+// header. See SchemaValidator.
+func Validate(validator *SchemaValidator) InsertOption {
+	return func(o *insertOptions) {
+		o.validator = validator
+	}
+}
+
+// MessageID returns the ID the message will be stored with: the supplied ID if set, otherwise one
+// derived from DeduplicationKey, otherwise a new random ID.
+func (r StorageRecord) MessageID() uuid.UUID {
+	switch {
+	case r.ID != uuid.Nil:
+		return r.ID
+	case r.DeduplicationKey != "":
+		return uuid.NewSHA1(deduplicationNamespace, []byte(r.DeduplicationKey))
+	default:
+		return uuid.New()
+	}
+}
+
+// insertColumns lists the columns written by InsertMessage and InsertMessages, in the order of insertArgs.
+const insertColumns = "id, event_type, aggregate_type, aggregate_id, data, topic, status, dedup_key, headers, " +
+	"available_at, priority, content_encoding, key_id, payload_ref"
+
+// notifyQuery notifies the channel of WithNotifyChannel. Postgres folds identical notifications of a
+// transaction into one, so a transaction wakes the relays once however many messages it inserts.
+// It is sent by the storage rather than by a trigger, so the channel stays an option of the storage
+// instead of being part of the schema.
+const notifyQuery = "SELECT pg_notify($1, '')"
+
+// maxInsertBatchSize caps the number of rows of a single multi-row INSERT, keeping it well below
+// the Postgres limit of 65535 bind parameters per statement.
+const maxInsertBatchSize = 1000
+
+// InsertMessage inserts a new message into the outbox table using tx, typically the caller's
+// transaction, and returns its ID.
+func (s *SQLStorage) InsertMessage(ctx context.Context, tx Executor, msg StorageRecord, opts ...InsertOption) (uuid.UUID, error) {
+	ids, err := s.InsertMessages(ctx, tx, []StorageRecord{msg}, opts...)
+	if len(ids) == 0 {
+		return uuid.Nil, err
+	}
+
+	return ids[0], err
+}
+
+// InsertMessages inserts several messages into the outbox table using multi-row INSERT statements,
+// and returns their IDs in the order of msgs. In IgnoreDuplicates mode ErrMessageExists is returned,
+// together with all IDs, when at least one of the messages was already stored.
+func (s *SQLStorage) InsertMessages(ctx context.Context, tx Executor, msgs []StorageRecord, opts ...InsertOption) ([]uuid.UUID, error) {
+	o, err := s.insertOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, statements, err := s.insertStatements(ctx, msgs, o)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates, inserted := false, false
+	for _, stmt := range statements {
+		result, err := tx.ExecContext(ctx, stmt.query, stmt.args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert outbox messages: %w", err)
+		}
+
+		if !o.ignoreDuplicates {
+			inserted = true
+
+			continue
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check affected rows: %w", err)
+		}
+		if rowsAffected < int64(stmt.rows) {
+			duplicates = true
+		}
+		if rowsAffected > 0 {
+			inserted = true
+		}
+	}
+
+	// Relays are only woken up if there is something new to publish.
+	if s.notifyChannel != "" && inserted {
+		if _, err = tx.ExecContext(ctx, notifyQuery, s.notifyChannel); err != nil {
+			return nil, fmt.Errorf("failed to notify outbox relays: %w", err)
+		}
+	}
+
+	if duplicates {
+		return ids, ErrMessageExists
+	}
+
+	return ids, nil
+}
+
+// insertOptions applies opts and checks that they are supported by the storage.
+func (s *SQLStorage) insertOptions(opts []InsertOption) (insertOptions, error) {
+	var o insertOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ignoreDuplicates && s.partitioned {
+		return o, errors.New("IgnoreDuplicates is not supported on partitioned outbox tables")
+	}
+
+	return o, nil
+}
+
+// insertStatement is a multi-row INSERT of rows messages.
+type insertStatement struct {
+	query string
+	args  []any
+	rows  int
+}
+
+// insertStatements applies the insert options to msgs (see insertOptions.apply) and builds the
+// INSERT statements storing them, at most maxInsertBatchSize rows each. It returns the IDs in the
+// order of msgs.
+func (s *SQLStorage) insertStatements(
+	ctx context.Context, msgs []StorageRecord, o insertOptions,
+) ([]uuid.UUID, []insertStatement, error) {
+	prepared, err := o.apply(ctx, msgs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(prepared))
+	var statements []insertStatement
+	for start := 0; start < len(prepared); start += maxInsertBatchSize {
+		batch := prepared[start:min(start+maxInsertBatchSize, len(prepared))]
+
+		var query strings.Builder
+		query.WriteString(s.sql("INSERT INTO {table} (" + insertColumns + ") VALUES "))
+
+		args := make([]any, 0, len(batch)*strings.Count(insertColumns, ",")+1)
+		for i, msg := range batch {
+			ids = append(ids, msg.ID)
+
+			rowArgs := insertArgs(msg)
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for j := range rowArgs {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+j+1)
+			}
+			query.WriteString(")")
+			args = append(args, rowArgs...)
+		}
+		if o.ignoreDuplicates {
+			query.WriteString(" ON CONFLICT DO NOTHING")
+		}
 
-	if msg.ID == uuid.Nil {
-		msg.ID = uuid.New()
+		statements = append(statements, insertStatement{query: query.String(), args: args, rows: len(batch)})
 	}
 
-	_, err := tx.ExecContext(ctx, query,
-		uuid.New(),
+	return ids, statements, nil
+}
+
+// insertArgs returns the values of insertColumns for msg, whose ID must already be set.
+func insertArgs(msg StorageRecord) []any {
+	return []any{
+		msg.ID,
 		msg.EventType,
 		msg.AggregateType,
 		msg.AggregateID,
 		msg.Data,
 		msg.Topic,
 		RecordStatusPending,
-	)
-
-	return err
+		nullString(msg.DeduplicationKey),
+		msg.Headers,
+		msg.AvailableAt,
+		msg.Priority,
+		msg.ContentEncoding,
+		msg.KeyID,
+		msg.PayloadRef,
+	}
 }
 
-// FetchPendingMessages retrieves pending messages ordered by creation time.
+// recordColumns lists the columns read into a StorageRecord, in the order of recordFields.
+const recordColumns = "id, event_type, aggregate_type, aggregate_id, data, created_at, status, attempts, topic, " +
+	"headers, available_at, next_attempt_at, last_error, last_error_at, sent_at, priority, content_encoding, key_id, payload_ref"
+
+// fetchPendingQuery selects the oldest pending message of each aggregate, see FetchPendingMessages.
+const fetchPendingQuery = `
+	WITH next_events AS (
+		SELECT DISTINCT ON (aggregate_type, aggregate_id)
+			` + recordColumns + `, seq,
+			MAX(priority) OVER (PARTITION BY aggregate_type, aggregate_id) AS aggregate_priority
+		FROM {table}
+		WHERE status IN ($1, $3)
+		ORDER BY aggregate_type, aggregate_id, created_at ASC, seq ASC
+	)
+	SELECT ` + recordColumns + `
+	FROM next_events
+	WHERE status = $1
+		AND (available_at IS NULL OR available_at <= NOW())
+		AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
+	ORDER BY aggregate_priority DESC, created_at ASC, seq ASC
+	LIMIT $2
+`
+
+// FetchPendingMessages retrieves pending messages ordered by priority and creation time. Only the oldest
+// pending message of each aggregate is returned, and only once it is available, so messages of one
+// aggregate are published in order. Messages waiting for their next attempt hold back their aggregate
+// as well. Aggregates are ranked by the highest priority among their pending messages, so a high
+// priority message speeds up the older messages of its aggregate rather than overtaking them.
+// Aggregates whose oldest message is blocked are skipped entirely.
+//
+// In row claiming mode (see WithRowClaiming) the returned messages are additionally claimed by this
+// storage for the claim lease, and messages claimed by others are skipped.
 func (s *SQLStorage) FetchPendingMessages(ctx context.Context, batchSize int) ([]*StorageRecord, error) {
-	const query = `
-        WITH next_events AS (
-            SELECT DISTINCT ON (aggregate_type, aggregate_id)
-                id, event_type, aggregate_type, aggregate_id, data, created_at, status, attempts, topic
-            FROM outbox
-            WHERE status = $1
-            ORDER BY aggregate_type, aggregate_id, created_at ASC
-        )
-        SELECT * FROM next_events
-        ORDER BY created_at ASC
-        LIMIT $2
-	`
+	if s.claimOwner != "" {
+		return s.claimPendingMessages(ctx, batchSize)
+	}
 
-	rows, err := s.db.QueryContext(ctx, query, RecordStatusPending, batchSize)
+	rows, err := s.db.QueryContext(ctx, s.sql(fetchPendingQuery), RecordStatusPending, batchSize, RecordStatusBlocked)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch pending messages: %w", err)
 	}
+
+	return scanRecords(rows)
+}
+
+// claimPendingQuery claims the oldest pending message of up to $2 aggregates, see claimPendingMessages.
+// Besides recordColumns it returns the priority of each message's aggregate and its seq, by which
+// scanClaimed restores the order of the claim.
+const claimPendingQuery = `
+	WITH next_events AS (
+		SELECT DISTINCT ON (aggregate_type, aggregate_id) id, created_at, seq,
+			MAX(priority) OVER (PARTITION BY aggregate_type, aggregate_id) AS aggregate_priority
+		FROM {table}
+		WHERE status IN ($1, $5)
+		ORDER BY aggregate_type, aggregate_id, created_at ASC, seq ASC
+	), claimable AS (
+		SELECT o.id AS claim_id, n.aggregate_priority AS claim_priority, n.seq AS claim_seq
+		FROM {table} o
+		JOIN next_events n ON n.id = o.id
+		WHERE o.status = $1
+			AND (o.available_at IS NULL OR o.available_at <= NOW())
+			AND (o.next_attempt_at IS NULL OR o.next_attempt_at <= NOW())
+			AND (o.claimed_until IS NULL OR o.claimed_until < NOW())
+		ORDER BY n.aggregate_priority DESC, n.created_at ASC, n.seq ASC
+		LIMIT $2
+		FOR UPDATE OF o SKIP LOCKED
+	)
+	UPDATE {table}
+	SET claimed_by = $3, claimed_until = NOW() + make_interval(secs => $4)
+	FROM claimable
+	WHERE id = claim_id
+	RETURNING ` + recordColumns + `, claim_priority, claim_seq
+`
+
+// claimPendingMessages claims the oldest pending message of up to batchSize aggregates. A message is
+// only claimable while it is the oldest pending one of its aggregate and not claimed by anyone else,
+// so each aggregate is processed by at most one relay at a time. The status and claim conditions
+// are checked on the locked row itself, so rows changed by a concurrent relay are re-evaluated.
+func (s *SQLStorage) claimPendingMessages(ctx context.Context, batchSize int) ([]*StorageRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.sql(claimPendingQuery),
+		RecordStatusPending, batchSize, s.claimOwner, s.claimLease.Seconds(), RecordStatusBlocked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim pending messages: %w", err)
+	}
+	defer rows.Close()
+
+	return scanClaimed(rows)
+}
+
+// claimedRows is implemented by the rows of database/sql and pgx.
+type claimedRows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// scanClaimed reads the rows of claimPendingQuery in the order the claim selected them, which
+// RETURNING does not preserve: by the priority of their aggregates, then oldest first.
+func scanClaimed(rows claimedRows) ([]*StorageRecord, error) {
+	type claimed struct {
+		rec               *StorageRecord
+		aggregatePriority int
+		seq               int64
+	}
+
+	var claims []claimed
+	for rows.Next() {
+		c := claimed{rec: &StorageRecord{}}
+		if err := rows.Scan(append(recordFields(c.rec), &c.aggregatePriority, &c.seq)...); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox record: %w", err)
+		}
+		claims = append(claims, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	sort.SliceStable(claims, func(i, j int) bool {
+		a, b := claims[i], claims[j]
+		if a.aggregatePriority != b.aggregatePriority {
+			return a.aggregatePriority > b.aggregatePriority
+		}
+		if !a.rec.CreatedAt.Equal(b.rec.CreatedAt) {
+			return a.rec.CreatedAt.Before(b.rec.CreatedAt)
+		}
+		return a.seq < b.seq
+	})
+
+	records := make([]*StorageRecord, len(claims))
+	for i, c := range claims {
+		records[i] = c.rec
+	}
+
+	return records, nil
+}
+
+// scanRecords reads all rows, selected as recordColumns, and closes them.
+func scanRecords(rows *sql.Rows) ([]*StorageRecord, error) {
 	defer rows.Close()
 
 	var records []*StorageRecord
 	for rows.Next() {
 		var rec StorageRecord
-		if err = rows.Scan(
-			&rec.ID,
-			&rec.EventType,
-			&rec.AggregateType,
-			&rec.AggregateID,
-			&rec.Data,
-			&rec.CreatedAt,
-			&rec.Status,
-			&rec.Attempts,
-			&rec.Topic,
-		); err != nil {
+		if err := rows.Scan(recordFields(&rec)...); err != nil {
 			return nil, fmt.Errorf("failed to scan outbox record: %w", err)
 		}
 		records = append(records, &rec)
 	}
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
 	return records, nil
 }
 
-// MarkMessageSent marks a message as successfully sent.
+// recordFields returns the scan destinations of recordColumns in rec.
+func recordFields(rec *StorageRecord) []any {
+	return []any{
+		&rec.ID,
+		&rec.EventType,
+		&rec.AggregateType,
+		&rec.AggregateID,
+		&rec.Data,
+		&rec.CreatedAt,
+		&rec.Status,
+		&rec.Attempts,
+		&rec.Topic,
+		&rec.Headers,
+		&rec.AvailableAt,
+		&rec.NextAttemptAt,
+		&rec.LastError,
+		&rec.LastErrorAt,
+		&rec.SentAt,
+		&rec.Priority,
+		&rec.ContentEncoding,
+		&rec.KeyID,
+		&rec.PayloadRef,
+	}
+}
+
+// markSentQuery marks a message as sent, see MarkMessageSent.
+const markSentQuery = `
+	UPDATE {table}
+	SET status = $1, sent_at = NOW()
+	WHERE id = $2
+`
+
+// MarkMessageSent marks a message as successfully sent. In archiving mode the message is moved to the
+// archive table in the same statement.
 func (s *SQLStorage) MarkMessageSent(ctx context.Context, id string) error {
-	const query = `
-		UPDATE outbox
-		SET status = $1, sent_at = NOW()
-		WHERE id = $2
-	`
-	result, err := s.db.ExecContext(ctx, query, RecordStatusSent, id)
+	query := markSentQuery
+	if s.archive {
+		query = archiveSentQuery
+	}
+
+	result, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusSent, id)
 	if err != nil {
 		return fmt.Errorf("failed to update message status to sent: %w", err)
 	}
@@ -159,28 +761,205 @@ func (s *SQLStorage) MarkMessageSent(ctx context.Context, id string) error {
 	return nil
 }
 
-// IncrementAttempt increments the attempt count for a message.
-func (s *SQLStorage) IncrementAttempt(ctx context.Context, id string) error {
-	const query = `
-		UPDATE outbox
-		SET attempts = attempts + 1
-		WHERE id = $1
-	`
-	if _, err := s.db.ExecContext(ctx, query, id); err != nil {
+// incrementAttemptQuery records a failed attempt of a message, see IncrementAttempt.
+const incrementAttemptQuery = `
+	UPDATE {table}
+	SET attempts = attempts + 1,
+		next_attempt_at = NOW() + make_interval(secs => $2),
+		last_error = $3,
+		last_error_at = NOW(),
+		claimed_by = NULL,
+		claimed_until = NULL
+	WHERE id = $1
+`
+
+// IncrementAttempt increments the attempt count for a message, records the reason of the failed
+// attempt and defers the next attempt by retryAfter.
+func (s *SQLStorage) IncrementAttempt(ctx context.Context, id string, retryAfter time.Duration, reason string) error {
+	if _, err := s.db.ExecContext(ctx, s.sql(incrementAttemptQuery), id, retryAfter.Seconds(), reason); err != nil {
 		return fmt.Errorf("failed to increment attempt count: %w", err)
 	}
 	return nil
 }
 
-// MarkMessageDead marks a message as dead (failed permanently).
-func (s *SQLStorage) MarkMessageDead(ctx context.Context, id string) error {
-	const query = `
-		UPDATE outbox
-		SET status = $1, sent_at = NOW()
-		WHERE id = $2
-	`
-	if _, err := s.db.ExecContext(ctx, query, RecordStatusDead, id); err != nil {
+// markDeadQuery marks a message as dead, see MarkMessageDead.
+const markDeadQuery = `
+	UPDATE {table}
+	SET status = $1,
+		sent_at = NOW(),
+		last_error = CASE WHEN last_error IS NULL THEN $3 ELSE $3 || ': ' || last_error END,
+		last_error_at = NOW()
+	WHERE id = $2
+`
+
+// MarkMessageDead marks a message as dead (failed permanently). The reason is recorded in front of the
+// error of the last failed attempt, if any. In archiving mode the message is moved to the archive table
+// in the same statement.
+func (s *SQLStorage) MarkMessageDead(ctx context.Context, id string, reason string) error {
+	query := markDeadQuery
+	if s.archive {
+		query = archiveDeadQuery
+	}
+
+	if _, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusDead, id, reason); err != nil {
 		return fmt.Errorf("failed to mark message as dead: %w", err)
 	}
 	return nil
 }
+
+// markBlockedQuery marks a message as blocked, see MarkMessageBlocked.
+const markBlockedQuery = `
+	UPDATE {table}
+	SET status = $1,
+		last_error = CASE WHEN last_error IS NULL THEN $3 ELSE $3 || ': ' || last_error END,
+		last_error_at = NOW(),
+		claimed_by = NULL,
+		claimed_until = NULL
+	WHERE id = $2
+`
+
+// MarkMessageBlocked marks a failed message as blocked, holding back all later messages of its aggregate
+// until it is requeued (see RequeueMessage) or marked dead. The reason is recorded like in MarkMessageDead.
+func (s *SQLStorage) MarkMessageBlocked(ctx context.Context, id string, reason string) error {
+	if _, err := s.db.ExecContext(ctx, s.sql(markBlockedQuery), RecordStatusBlocked, id, reason); err != nil {
+		return fmt.Errorf("failed to mark message as blocked: %w", err)
+	}
+	return nil
+}
+
+// markAggregateDeadQuery marks a message and the later pending messages of its aggregate as dead, see
+// MarkAggregateDead.
+const markAggregateDeadQuery = `
+	UPDATE {table}
+	SET status = $1,
+		sent_at = NOW(),
+		last_error = CASE
+			WHEN id = $2 THEN CASE WHEN last_error IS NULL THEN $3 ELSE $3 || ': ' || last_error END
+			ELSE $4
+		END,
+		last_error_at = NOW()
+	WHERE status = $5
+		AND (aggregate_type, aggregate_id) = (SELECT aggregate_type, aggregate_id FROM {table} WHERE id = $2)
+`
+
+// MarkAggregateDead marks a message as dead together with all later pending messages of its aggregate.
+// The reason is recorded on the message like in MarkMessageDead, while the later messages refer to it.
+// In archiving mode the messages are moved to the archive table in the same statement.
+func (s *SQLStorage) MarkAggregateDead(ctx context.Context, id string, reason string) error {
+	query := markAggregateDeadQuery
+	if s.archive {
+		query = archiveAggregateDeadQuery
+	}
+
+	followerReason := fmt.Sprintf("preceding message %s is dead", id)
+	if _, err := s.db.ExecContext(ctx, s.sql(query),
+		RecordStatusDead, id, reason, followerReason, RecordStatusPending,
+	); err != nil {
+		return fmt.Errorf("failed to mark aggregate as dead: %w", err)
+	}
+	return nil
+}
+
+// fetchBlockedQuery selects the oldest blocked messages, see FetchBlockedMessages.
+const fetchBlockedQuery = `
+	SELECT ` + recordColumns + `
+	FROM {table}
+	WHERE status = $1
+	ORDER BY created_at ASC, seq ASC
+	LIMIT $2
+`
+
+// FetchBlockedMessages retrieves up to limit blocked messages, oldest first. Each of them holds back
+// its aggregate, see MarkMessageBlocked.
+func (s *SQLStorage) FetchBlockedMessages(ctx context.Context, limit int) ([]*StorageRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.sql(fetchBlockedQuery), RecordStatusBlocked, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blocked messages: %w", err)
+	}
+
+	return scanRecords(rows)
+}
+
+// requeueQuery makes a blocked message pending again, see RequeueMessage.
+const requeueQuery = `
+	UPDATE {table}
+	SET status = $1, attempts = 0, next_attempt_at = NULL
+	WHERE id = $2 AND status = $3
+`
+
+// RequeueMessage makes a blocked message pending again with a fresh attempt count, unblocking its
+// aggregate. To skip the message instead, see SkipBlockedMessage.
+func (s *SQLStorage) RequeueMessage(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, s.sql(requeueQuery), RecordStatusPending, id, RecordStatusBlocked)
+	if err != nil {
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// skipBlockedQuery marks a blocked message as dead, see SkipBlockedMessage.
+const skipBlockedQuery = `
+	UPDATE {table}
+	SET status = $1,
+		sent_at = NOW(),
+		last_error = CASE WHEN last_error IS NULL THEN $3 ELSE $3 || ': ' || last_error END,
+		last_error_at = NOW()
+	WHERE id = $2 AND status = $4
+`
+
+// SkipBlockedMessage marks a blocked message as dead like MarkMessageDead, unblocking its aggregate.
+// Unlike MarkMessageDead it leaves messages which are not blocked untouched and returns ErrNotFound.
+func (s *SQLStorage) SkipBlockedMessage(ctx context.Context, id string, reason string) error {
+	query := skipBlockedQuery
+	if s.archive {
+		query = archiveSkipBlockedQuery
+	}
+
+	result, err := s.db.ExecContext(ctx, s.sql(query), RecordStatusDead, id, reason, RecordStatusBlocked)
+	if err != nil {
+		return fmt.Errorf("failed to skip blocked message: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// nullString maps an empty string to SQL NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// DeleteMessages deletes up to limit messages of the given status which were sent (or marked dead)
+// before olderThan, and returns how many were deleted. Rows locked by others are skipped.
+func (s *SQLStorage) DeleteMessages(ctx context.Context, status string, olderThan time.Time, limit int) (int64, error) {
+	const query = `
+		DELETE FROM {table}
+		WHERE id IN (
+			SELECT id FROM {table}
+			WHERE status = $1 AND sent_at < $2
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+	result, err := s.db.ExecContext(ctx, s.sql(query), status, olderThan, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete messages: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	return rowsAffected, nil
+}
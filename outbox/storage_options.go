@@ -0,0 +1,139 @@
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultTableName is the name of the outbox table when no WithTableName option is given.
+const DefaultTableName = "outbox"
+
+// identifierPattern restricts schema and table names to plain SQL identifiers. Postgres truncates
+// identifiers longer than 63 bytes, so longer names are rejected as well.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// maxTableNameLength leaves room for the suffixes of derived tables (e.g. "_schema_migrations")
+// within the 63 bytes Postgres allows for identifiers.
+const maxTableNameLength = 45
+
+// SQLStorageOption configures an SQLStorage.
+type SQLStorageOption func(*SQLStorage) error
+
+// WithSchema places the outbox tables in the given schema instead of the connection's search_path.
+// The schema must already exist.
+func WithSchema(schema string) SQLStorageOption {
+	return func(s *SQLStorage) error {
+		if schema == "" {
+			return nil
+		}
+		if !identifierPattern.MatchString(schema) {
+			return fmt.Errorf("invalid outbox schema name %q", schema)
+		}
+		s.schema = schema
+
+		return nil
+	}
+}
+
+// WithTableName overrides the outbox table name, which defaults to DefaultTableName.
+// Derived objects (indexes, the migrations table) are named after it.
+func WithTableName(name string) SQLStorageOption {
+	return func(s *SQLStorage) error {
+		if name == "" {
+			return nil
+		}
+		if !identifierPattern.MatchString(name) || len(name) > maxTableNameLength {
+			return fmt.Errorf("invalid outbox table name %q", name)
+		}
+		s.tableName = name
+
+		return nil
+	}
+}
+
+// WithRowClaiming switches FetchPendingMessages to row claiming mode, in which several relays can
+// process the outbox concurrently without a LeaderElector. Fetched messages are claimed by owner
+// for the given lease using FOR UPDATE SKIP LOCKED, while the order of messages within an aggregate
+// is kept. The lease must exceed the time a relay needs to process a batch. An empty owner defaults
+// to the host name and process ID.
+func WithRowClaiming(owner string, lease time.Duration) SQLStorageOption {
+	return func(s *SQLStorage) error {
+		if lease <= 0 {
+			return fmt.Errorf("invalid claim lease %s", lease)
+		}
+
+		if owner == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("failed to determine claim owner: %w", err)
+			}
+			owner = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+
+		s.claimOwner = owner
+		s.claimLease = lease
+
+		return nil
+	}
+}
+
+// WithArchiving moves messages to the archive table (named after the outbox table with an "_archive"
+// suffix) as soon as they are marked sent or dead, keeping the outbox table small. Archived messages
+// can be read with FetchArchivedMessages.
+//
+// Archived messages are not considered by IgnoreDuplicates, so a message inserted again after its
+// duplicate was archived is published again, and then replaces it in the archive.
+func WithArchiving() SQLStorageOption {
+	return func(s *SQLStorage) error {
+		s.archive = true
+
+		return nil
+	}
+}
+
+// WithPartitioning makes Migrate create the outbox table range-partitioned by created_at, with a
+// default partition catching rows outside of all daily partitions. Daily partitions are created and
+// dropped by a PartitionManager. It must be set before the table is created; existing tables are not
+// converted.
+//
+// The primary key of a partitioned table is (id, created_at), so IDs are not unique across
+// transactions anymore and IgnoreDuplicates is not supported.
+func WithPartitioning() SQLStorageOption {
+	return func(s *SQLStorage) error {
+		s.partitioned = true
+
+		return nil
+	}
+}
+
+// WithNotifyChannel makes InsertMessage and InsertMessages notify the given Postgres channel with
+// pg_notify, which is delivered to listening relays when the transaction commits, see
+// NotifyListener. Relays which are not listening still pick up the messages with their next poll.
+func WithNotifyChannel(channel string) SQLStorageOption {
+	return func(s *SQLStorage) error {
+		if channel == "" {
+			return nil
+		}
+		if !identifierPattern.MatchString(channel) {
+			return fmt.Errorf("invalid notify channel name %q", channel)
+		}
+		s.notifyChannel = channel
+
+		return nil
+	}
+}
+
+// quoteIdentifier quotes the given name, optionally qualified by a schema, for use in SQL.
+func quoteIdentifier(schema, name string) string {
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	if schema == "" {
+		return quote(name)
+	}
+
+	return quote(schema) + "." + quote(name)
+}
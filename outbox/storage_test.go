@@ -0,0 +1,1366 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestSQLStorage_InsertMessage(t *testing.T) {
+	suppliedID := uuid.New()
+
+	tests := []struct {
+		name          string
+		msg           StorageRecord
+		opts          []InsertOption
+		mockSetup     func(mock sqlmock.Sqlmock, expectedID uuid.UUID)
+		expectedID    uuid.UUID
+		expectedError error
+	}{
+		{
+			name: "#1 Persists the supplied ID",
+			msg: StorageRecord{
+				ID:            suppliedID,
+				EventType:     "UserCreated",
+				AggregateType: "User",
+				AggregateID:   "123",
+				Data:          []byte(`{"name":"John"}`),
+				Topic:         "users",
+				Headers:       Headers{"tenant": "acme"},
+			},
+			mockSetup: func(mock sqlmock.Sqlmock, expectedID uuid.UUID) {
+				mock.ExpectExec(`INSERT INTO "outbox"`).
+					WithArgs(expectedID, "UserCreated", "User", "123", []byte(`{"name":"John"}`), "users",
+						RecordStatusPending, nil, `{"tenant":"acme"}`, nil, 0, "", "", "").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedID: suppliedID,
+		},
+		{
+			name: "#2 Derives the ID from the deduplication key",
+			msg: StorageRecord{
+				DeduplicationKey: "user-123-created",
+				EventType:        "UserCreated",
+				AggregateType:    "User",
+				AggregateID:      "123",
+				Data:             []byte(`{}`),
+				Topic:            "users",
+			},
+			mockSetup: func(mock sqlmock.Sqlmock, expectedID uuid.UUID) {
+				mock.ExpectExec(`INSERT INTO "outbox"`).
+					WithArgs(expectedID, "UserCreated", "User", "123", []byte(`{}`), "users",
+						RecordStatusPending, "user-123-created", "{}", nil, 0, "", "", "").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedID: uuid.NewSHA1(deduplicationNamespace, []byte("user-123-created")),
+		},
+		{
+			name: "#3 Reports an existing message in IgnoreDuplicates mode",
+			msg: StorageRecord{
+				ID:            suppliedID,
+				EventType:     "UserCreated",
+				AggregateType: "User",
+				AggregateID:   "123",
+				Data:          []byte(`{}`),
+				Topic:         "users",
+			},
+			opts: []InsertOption{IgnoreDuplicates()},
+			mockSetup: func(mock sqlmock.Sqlmock, _ uuid.UUID) {
+				mock.ExpectExec(`INSERT INTO "outbox" .* ON CONFLICT DO NOTHING`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			expectedID:    suppliedID,
+			expectedError: ErrMessageExists,
+		},
+		{
+			name: "#4 Schedules the delivery",
+			msg: StorageRecord{
+				ID:            suppliedID,
+				EventType:     "ReminderDue",
+				AggregateType: "User",
+				AggregateID:   "123",
+				Data:          []byte(`{}`),
+				Topic:         "reminders",
+			},
+			opts: []InsertOption{DeliverAfter(time.Hour)},
+			mockSetup: func(mock sqlmock.Sqlmock, expectedID uuid.UUID) {
+				mock.ExpectExec(`INSERT INTO "outbox"`).
+					WithArgs(expectedID, "ReminderDue", "User", "123", []byte(`{}`), "reminders",
+						RecordStatusPending, nil, "{}", timeAround(time.Now().Add(time.Hour)), 0, "", "", "").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedID: suppliedID,
+		},
+		{
+			name: "#5 Persists the priority",
+			msg: StorageRecord{
+				ID:            suppliedID,
+				EventType:     "PaymentConfirmed",
+				AggregateType: "Payment",
+				AggregateID:   "42",
+				Data:          []byte(`{}`),
+				Topic:         "payments",
+				Priority:      10,
+			},
+			mockSetup: func(mock sqlmock.Sqlmock, expectedID uuid.UUID) {
+				mock.ExpectExec(`INSERT INTO "outbox" \(.*, priority, content_encoding, key_id, payload_ref\)`).
+					WithArgs(expectedID, "PaymentConfirmed", "Payment", "42", []byte(`{}`), "payments",
+						RecordStatusPending, nil, "{}", nil, 10, "", "", "").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedID: suppliedID,
+		},
+		{
+			name: "#6 Compresses the data",
+			msg: StorageRecord{
+				ID:            suppliedID,
+				EventType:     "UserCreated",
+				AggregateType: "User",
+				AggregateID:   "123",
+				Data:          []byte(`{"name":"John"}`),
+				Topic:         "users",
+			},
+			opts: []InsertOption{Compress(ContentEncodingGzip)},
+			mockSetup: func(mock sqlmock.Sqlmock, expectedID uuid.UUID) {
+				data, _ := compressData(ContentEncodingGzip, []byte(`{"name":"John"}`))
+				mock.ExpectExec(`INSERT INTO "outbox"`).
+					WithArgs(expectedID, "UserCreated", "User", "123", data, "users",
+						RecordStatusPending, nil, "{}", nil, 0, ContentEncodingGzip, "", "").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedID: suppliedID,
+		},
+		{
+			name: "#7 Rejects an unsupported content encoding",
+			msg: StorageRecord{
+				ID:    suppliedID,
+				Topic: "users",
+			},
+			opts:          []InsertOption{Compress("br")},
+			mockSetup:     func(sqlmock.Sqlmock, uuid.UUID) {},
+			expectedID:    uuid.Nil,
+			expectedError: errors.New(`unsupported content encoding "br"`),
+		},
+		{
+			name: "#8 Database error",
+			msg: StorageRecord{
+				ID:    suppliedID,
+				Topic: "users",
+			},
+			mockSetup: func(mock sqlmock.Sqlmock, _ uuid.UUID) {
+				mock.ExpectExec(`INSERT INTO "outbox"`).
+					WillReturnError(errors.New("database error"))
+			},
+			expectedID:    uuid.Nil,
+			expectedError: errors.New("database error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			tt.mockSetup(mock, tt.expectedID)
+
+			storage, err := NewSQLStorage(db)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			ctx := context.Background()
+
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatalf("failed to begin transaction: %v", err)
+			}
+
+			id, err := storage.InsertMessage(ctx, tx, tt.msg, tt.opts...)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Fatalf("unexpected error: got %v, want %v", err, tt.expectedError)
+			}
+			if errors.Is(tt.expectedError, ErrMessageExists) && !errors.Is(err, ErrMessageExists) {
+				t.Errorf("unexpected error: got %v, want %v", err, ErrMessageExists)
+			}
+			if id != tt.expectedID {
+				t.Errorf("unexpected id: got %v, want %v", id, tt.expectedID)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_InsertMessage_Executors(t *testing.T) {
+	tests := []struct {
+		name     string
+		executor func(ctx context.Context, db *sql.DB, mock sqlmock.Sqlmock) (Executor, error)
+	}{
+		{
+			name: "#1 Inserts within a savepoint on a connection",
+			executor: func(ctx context.Context, db *sql.DB, mock sqlmock.Sqlmock) (Executor, error) {
+				mock.ExpectExec("SAVEPOINT outbox").WillReturnResult(sqlmock.NewResult(0, 0))
+
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					return nil, err
+				}
+				_, err = conn.ExecContext(ctx, "SAVEPOINT outbox")
+
+				return conn, err
+			},
+		},
+		{
+			name: "#2 Inserts through an adapted function",
+			executor: func(ctx context.Context, db *sql.DB, mock sqlmock.Sqlmock) (Executor, error) {
+				mock.ExpectBegin()
+
+				tx, err := db.BeginTx(ctx, nil)
+				if err != nil {
+					return nil, err
+				}
+
+				// E.g. a transaction of a library which does not expose ExecContext itself.
+				return ExecutorFunc(func(ctx context.Context, query string, args ...any) (sql.Result, error) {
+					return tx.ExecContext(ctx, query, args...)
+				}), nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewSQLStorage(db)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			ctx := context.Background()
+
+			executor, err := tt.executor(ctx, db, mock)
+			if err != nil {
+				t.Fatalf("failed to create executor: %v", err)
+			}
+
+			msg := StorageRecord{ID: uuid.New(), EventType: "UserCreated", AggregateType: "User", AggregateID: "1",
+				Topic: "users"}
+			mock.ExpectExec(`INSERT INTO "outbox"`).
+				WithArgs(msg.ID, "UserCreated", "User", "1", []byte(nil), "users", RecordStatusPending, nil, "{}",
+					nil, 0, "", "", "").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			id, err := storage.InsertMessage(ctx, executor, msg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id != msg.ID {
+				t.Errorf("unexpected id: got %v, want %v", id, msg.ID)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_InsertMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	msgs := make([]StorageRecord, maxInsertBatchSize+1)
+	for i := range msgs {
+		msgs[i] = StorageRecord{
+			EventType:     "UserImported",
+			AggregateType: "User",
+			AggregateID:   fmt.Sprintf("%d", i),
+			Data:          []byte(`{}`),
+			Topic:         "users",
+		}
+	}
+	suppliedID := uuid.New()
+	msgs[0].ID = suppliedID
+
+	columns := len(strings.Split(insertColumns, ","))
+
+	mock.ExpectBegin()
+	// The messages exceed one batch, so they are written with two statements.
+	mock.ExpectExec(fmt.Sprintf(`INSERT INTO "outbox" \(.+\) VALUES \(\$1, .+\), \(\$%d, .+\$%d\) ON CONFLICT DO NOTHING$`,
+		columns+1, columns*maxInsertBatchSize)).
+		WillReturnResult(sqlmock.NewResult(0, maxInsertBatchSize))
+	mock.ExpectExec(fmt.Sprintf(`INSERT INTO "outbox" \(.+\) VALUES \(\$1, .+, \$%d\) ON CONFLICT DO NOTHING$`, columns)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+
+	ids, err := storage.InsertMessages(ctx, tx, msgs, IgnoreDuplicates())
+	if !errors.Is(err, ErrMessageExists) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrMessageExists)
+	}
+	if len(ids) != len(msgs) {
+		t.Fatalf("unexpected number of ids: got %d, want %d", len(ids), len(msgs))
+	}
+	if ids[0] != suppliedID {
+		t.Errorf("unexpected first id: got %v, want %v", ids[0], suppliedID)
+	}
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		if id == uuid.Nil || seen[id] {
+			t.Fatalf("unexpected id %v", id)
+		}
+		seen[id] = true
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// benchDSNEnv names the environment variable with the DSN of the Postgres database the insert
+// benchmarks run against. The benchmarks are skipped without it.
+const benchDSNEnv = "OUTBOX_BENCH_DSN"
+
+// benchmarkInsert inserts 1000 messages per iteration into a real Postgres database, either one by
+// one or in bulk. It creates its own outbox tables and drops them afterwards.
+func benchmarkInsert(b *testing.B, bulk bool) {
+	const messages = 1000
+
+	dsn := os.Getenv(benchDSNEnv)
+	if dsn == "" {
+		b.Skipf("%s is not set", benchDSNEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		b.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	storage, err := NewSQLStorage(db, WithTableName("outbox_bench"))
+	if err != nil {
+		b.Fatalf("failed to create storage: %v", err)
+	}
+	if err = storage.Migrate(ctx); err != nil {
+		b.Fatalf("failed to migrate: %v", err)
+	}
+	defer func() {
+		_, err := db.ExecContext(ctx, storage.sql("DROP TABLE {table}, {archive_table}, {migrations_table}"))
+		if err != nil {
+			b.Errorf("failed to drop benchmark tables: %v", err)
+		}
+	}()
+
+	msgs := make([]StorageRecord, messages)
+	for i := range msgs {
+		msgs[i] = StorageRecord{
+			EventType:     "UserImported",
+			AggregateType: "User",
+			AggregateID:   fmt.Sprintf("%d", i),
+			Data:          []byte(`{"name":"John"}`),
+			Topic:         "users",
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			b.Fatalf("failed to begin transaction: %v", err)
+		}
+		if bulk {
+			if _, err = storage.InsertMessages(ctx, tx, msgs); err != nil {
+				b.Fatalf("failed to insert messages: %v", err)
+			}
+		} else {
+			for _, msg := range msgs {
+				if _, err = storage.InsertMessage(ctx, tx, msg); err != nil {
+					b.Fatalf("failed to insert message: %v", err)
+				}
+			}
+		}
+		if err = tx.Commit(); err != nil {
+			b.Fatalf("failed to commit transaction: %v", err)
+		}
+
+		b.StopTimer()
+		if _, err = db.ExecContext(ctx, storage.sql("TRUNCATE {table}")); err != nil {
+			b.Fatalf("failed to truncate outbox table: %v", err)
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkSQLStorage_InsertMessage(b *testing.B) {
+	benchmarkInsert(b, false)
+}
+
+func BenchmarkSQLStorage_InsertMessages(b *testing.B) {
+	benchmarkInsert(b, true)
+}
+
+func TestSQLStorage_FetchPendingMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	availableAt := time.Now().Add(-time.Minute)
+	expected := &StorageRecord{
+		ID:            uuid.New(),
+		EventType:     "UserCreated",
+		AggregateType: "User",
+		AggregateID:   "123",
+		Data:          []byte(`{}`),
+		CreatedAt:     time.Now(),
+		Status:        RecordStatusPending,
+		Attempts:      1,
+		Topic:         "users",
+		Headers:       Headers{"tenant": "acme", "content-type": "application/json"},
+		AvailableAt:   &availableAt,
+		Priority:      5,
+	}
+	mock.ExpectQuery(`SELECT DISTINCT ON \(aggregate_type, aggregate_id\).+WHERE status = \$1\s+AND \(available_at IS NULL OR available_at <= NOW\(\)\)\s+AND \(next_attempt_at IS NULL OR next_attempt_at <= NOW\(\)\)`).
+		WithArgs(RecordStatusPending, 10, RecordStatusBlocked).
+		WillReturnRows(recordRows(expected))
+
+	records, err := storage.FetchPendingMessages(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("unexpected number of records: got %d, want 1", len(records))
+	}
+
+	if !reflect.DeepEqual(records[0], expected) {
+		t.Errorf("unexpected record: got %+v, want %+v", records[0], expected)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_FetchPendingMessages_RowClaiming(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db, WithRowClaiming("relay-1", 30*time.Second))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	now := time.Now()
+	newer := &StorageRecord{ID: uuid.New(), AggregateType: "User", AggregateID: "2", CreatedAt: now}
+	older := &StorageRecord{ID: uuid.New(), AggregateType: "User", AggregateID: "1", CreatedAt: now.Add(-time.Second)}
+	// The head of an urgent aggregate comes first, although the message itself has the default priority.
+	urgent := &StorageRecord{ID: uuid.New(), AggregateType: "Payment", AggregateID: "1", CreatedAt: now, Priority: 0}
+	important := &StorageRecord{ID: uuid.New(), AggregateType: "Order", AggregateID: "1", CreatedAt: now, Priority: 5}
+
+	rows := claimedRecordRows(
+		claimedRow{rec: newer, seq: 4},
+		claimedRow{rec: important, aggregatePriority: 5, seq: 3},
+		claimedRow{rec: older, seq: 1},
+		claimedRow{rec: urgent, aggregatePriority: 10, seq: 2},
+	)
+	mock.ExpectQuery(`FOR UPDATE OF o SKIP LOCKED.+UPDATE "outbox"\s+SET claimed_by = \$3.+claim_priority, claim_seq`).
+		WithArgs(RecordStatusPending, 10, "relay-1", float64(30), RecordStatusBlocked).
+		WillReturnRows(rows)
+
+	records, err := storage.FetchPendingMessages(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []uuid.UUID{urgent.ID, important.ID, older.ID, newer.ID}
+	got := make([]uuid.UUID, 0, len(records))
+	for _, rec := range records {
+		got = append(got, rec.ID)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected order: got %v, want %v", got, expected)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_FetchPendingMessages_Priority(t *testing.T) {
+	// The head of every aggregate is chosen by insertion order only, while the heads are ranked by
+	// the highest priority pending in their aggregate.
+	const (
+		aggregateHead = `SELECT DISTINCT ON \(aggregate_type, aggregate_id\)[^;]+` +
+			`MAX\(priority\) OVER \(PARTITION BY aggregate_type, aggregate_id\) AS aggregate_priority\s+` +
+			`FROM "outbox"\s+WHERE status IN \(\$1, \$(3|5)\)\s+` +
+			`ORDER BY aggregate_type, aggregate_id, created_at ASC, seq ASC\s+\)`
+		aggregateRanking = `ORDER BY (n\.)?aggregate_priority DESC, (n\.)?created_at ASC, (n\.)?seq ASC`
+	)
+
+	now := time.Now()
+	bulk := &StorageRecord{ID: uuid.New(), AggregateType: "User", AggregateID: "1", CreatedAt: now.Add(-time.Minute)}
+	payment := &StorageRecord{
+		ID: uuid.New(), AggregateType: "Payment", AggregateID: "42", CreatedAt: now, Priority: 10,
+	}
+
+	tests := []struct {
+		name string
+		opts []SQLStorageOption
+		args []driver.Value
+		rows func() *sqlmock.Rows
+	}{
+		{
+			name: "#1 Polling",
+			args: []driver.Value{RecordStatusPending, 10, RecordStatusBlocked},
+			rows: func() *sqlmock.Rows { return recordRows(payment, bulk) },
+		},
+		{
+			name: "#2 Row claiming",
+			opts: []SQLStorageOption{WithRowClaiming("relay-1", 30*time.Second)},
+			args: []driver.Value{RecordStatusPending, 10, "relay-1", float64(30), RecordStatusBlocked},
+			rows: func() *sqlmock.Rows {
+				return claimedRecordRows(
+					claimedRow{rec: payment, aggregatePriority: 10, seq: 2},
+					claimedRow{rec: bulk, seq: 1},
+				)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewSQLStorage(db, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			mock.ExpectQuery(aggregateHead + `.+` + aggregateRanking).
+				WithArgs(tt.args...).
+				WillReturnRows(tt.rows())
+
+			records, err := storage.FetchPendingMessages(context.Background(), 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(records) != 2 || records[0].ID != payment.ID || records[1].ID != bulk.ID {
+				t.Errorf("unexpected records: got %+v", records)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_IncrementAttempt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	id := uuid.New().String()
+	mock.ExpectExec(`UPDATE "outbox"\s+SET attempts = attempts \+ 1,\s+next_attempt_at = NOW\(\) \+ make_interval\(secs => \$2\),\s+last_error = \$3`).
+		WithArgs(id, float64(90), "nats: timeout").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err = storage.IncrementAttempt(context.Background(), id, 90*time.Second, "nats: timeout"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_MarkMessageDead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	id := uuid.New().String()
+	mock.ExpectExec(`UPDATE "outbox"\s+SET status = \$1,.+last_error = CASE WHEN last_error IS NULL THEN \$3 ELSE \$3 \|\| ': ' \|\| last_error END`).
+		WithArgs(RecordStatusDead, id, "exceeded max attempts (3)").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err = storage.MarkMessageDead(context.Background(), id, "exceeded max attempts (3)"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_MarkMessageBlocked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	id := uuid.New().String()
+	mock.ExpectExec(`UPDATE "outbox"\s+SET status = \$1,\s+last_error = .+claimed_by = NULL`).
+		WithArgs(RecordStatusBlocked, id, "exceeded max attempts (3)").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err = storage.MarkMessageBlocked(context.Background(), id, "exceeded max attempts (3)"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_MarkAggregateDead(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []SQLStorageOption
+		query string
+	}{
+		{
+			name:  "#1 Marks the remaining aggregate stream dead",
+			query: `UPDATE "outbox"\s+SET status = \$1,.+WHERE status = \$5\s+AND \(aggregate_type, aggregate_id\) = \(SELECT`,
+		},
+		{
+			name:  "#2 Moves the remaining aggregate stream to the archive",
+			opts:  []SQLStorageOption{WithArchiving()},
+			query: `DELETE FROM "outbox"\s+WHERE status = \$5.+INSERT INTO "outbox_archive"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewSQLStorage(db, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			id := uuid.New().String()
+			mock.ExpectExec(tt.query).
+				WithArgs(RecordStatusDead, id, "exceeded max attempts (3)", "preceding message "+id+" is dead",
+					RecordStatusPending).
+				WillReturnResult(sqlmock.NewResult(0, 4))
+
+			if err = storage.MarkAggregateDead(context.Background(), id, "exceeded max attempts (3)"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_FetchBlockedMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	reason := "exceeded max attempts (3)"
+	expected := &StorageRecord{
+		ID:            uuid.New(),
+		EventType:     "UserCreated",
+		AggregateType: "User",
+		AggregateID:   "123",
+		CreatedAt:     time.Now(),
+		Status:        RecordStatusBlocked,
+		Attempts:      3,
+		LastError:     &reason,
+	}
+	mock.ExpectQuery(`FROM "outbox"\s+WHERE status = \$1\s+ORDER BY created_at ASC, seq ASC\s+LIMIT \$2`).
+		WithArgs(RecordStatusBlocked, 100).
+		WillReturnRows(recordRows(expected))
+
+	records, err := storage.FetchBlockedMessages(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0], expected) {
+		t.Errorf("unexpected records: got %+v", records)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_RequeueMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		rowsAffected  int64
+		expectedError error
+	}{
+		{
+			name:         "#1 Requeues a blocked message",
+			rowsAffected: 1,
+		},
+		{
+			name:          "#2 Message is not blocked",
+			rowsAffected:  0,
+			expectedError: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewSQLStorage(db)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			id := uuid.New().String()
+			mock.ExpectExec(`UPDATE "outbox"\s+SET status = \$1, attempts = 0, next_attempt_at = NULL\s+WHERE id = \$2 AND status = \$3`).
+				WithArgs(RecordStatusPending, id, RecordStatusBlocked).
+				WillReturnResult(sqlmock.NewResult(0, tt.rowsAffected))
+
+			err = storage.RequeueMessage(context.Background(), id)
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.expectedError)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_SkipBlockedMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []SQLStorageOption
+		query         string
+		rowsAffected  int64
+		expectedError error
+	}{
+		{
+			name:         "#1 Marks a blocked message dead",
+			query:        `UPDATE "outbox"\s+SET status = \$1,.+WHERE id = \$2 AND status = \$4`,
+			rowsAffected: 1,
+		},
+		{
+			name:          "#2 Leaves a message which is not blocked",
+			query:         `UPDATE "outbox"\s+SET status = \$1,.+WHERE id = \$2 AND status = \$4`,
+			rowsAffected:  0,
+			expectedError: ErrNotFound,
+		},
+		{
+			name:         "#3 Moves the message to the archive in archiving mode",
+			opts:         []SQLStorageOption{WithArchiving()},
+			query:        `DELETE FROM "outbox"\s+WHERE id = \$2 AND status = \$4.+INSERT INTO "outbox_archive"`,
+			rowsAffected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewSQLStorage(db, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			id := uuid.New().String()
+			mock.ExpectExec(tt.query).
+				WithArgs(RecordStatusDead, id, "skipped by operator", RecordStatusBlocked).
+				WillReturnResult(sqlmock.NewResult(0, tt.rowsAffected))
+
+			err = storage.SkipBlockedMessage(context.Background(), id, "skipped by operator")
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.expectedError)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_DeleteMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	olderThan := time.Now().Add(-time.Hour)
+	mock.ExpectExec(`DELETE FROM "outbox"\s+WHERE id IN \(.+LIMIT \$3\s+FOR UPDATE SKIP LOCKED`).
+		WithArgs(RecordStatusSent, olderThan, 500).
+		WillReturnResult(sqlmock.NewResult(0, 500))
+
+	deleted, err := storage.DeleteMessages(context.Background(), RecordStatusSent, olderThan, 500)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if deleted != 500 {
+		t.Errorf("unexpected number of deleted messages: got %d, want 500", deleted)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_ArchiveMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db, WithPartitioning())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	// Two of the moved rows share an ID, so only 499 rows are archived, but all 500 count as moved.
+	olderThan := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(`WITH moved AS \(\s*DELETE FROM "outbox".+LIMIT \$3\s+FOR UPDATE SKIP LOCKED.+`+
+		`archived AS \(\s*INSERT INTO "outbox_archive".+SELECT COUNT\(\*\) FROM moved`).
+		WithArgs(RecordStatusSent, olderThan, 500).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(500))
+
+	moved, err := storage.ArchiveMessages(context.Background(), RecordStatusSent, olderThan, 500)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if moved != 500 {
+		t.Errorf("unexpected number of moved messages: got %d, want 500", moved)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_MarkMessageSent_Archiving(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db, WithArchiving())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	id := uuid.New().String()
+	// A message whose ID is already archived replaces the archived message instead of failing.
+	mock.ExpectExec(`WITH moved AS \(\s*DELETE FROM "outbox"\s+WHERE id = \$2.+INSERT INTO "outbox_archive".+`+
+		`ON CONFLICT \(id\) DO UPDATE SET`).
+		WithArgs(RecordStatusSent, id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err = storage.MarkMessageSent(context.Background(), id); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestArchiveConflictClause(t *testing.T) {
+	// Every column of an archived message must be replaced by the message archived again.
+	columns := strings.Split(archiveCopyColumns+", last_error, last_error_at, status, sent_at, archived_at", ", ")
+	for _, column := range columns {
+		if column == "id" {
+			continue
+		}
+		if !strings.Contains(archiveConflictClause, column+" = ") {
+			t.Errorf("column %s is not updated on conflict", column)
+		}
+	}
+}
+
+func TestSQLStorage_FetchArchivedMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db, WithSchema("billing"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	sentAt := time.Now()
+	expected := &StorageRecord{
+		ID:            uuid.New(),
+		EventType:     "UserCreated",
+		AggregateType: "User",
+		AggregateID:   "123",
+		Data:          []byte(`{}`),
+		CreatedAt:     sentAt.Add(-time.Second),
+		SentAt:        &sentAt,
+		Status:        RecordStatusSent,
+		Topic:         "users",
+	}
+	mock.ExpectQuery(`FROM "billing"."outbox_archive"\s+WHERE aggregate_type = \$1 AND aggregate_id = \$2`).
+		WithArgs("User", "123", 50).
+		WillReturnRows(recordRows(expected))
+
+	records, err := storage.FetchArchivedMessages(context.Background(), "User", "123", 50)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0], expected) {
+		t.Errorf("unexpected records: got %+v", records)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_CreatePartition(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db, WithSchema("billing"), WithPartitioning())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	day := time.Date(2024, time.January, 31, 22, 30, 0, 0, time.FixedZone("", -3*60*60))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS "billing"."outbox_p20240201" PARTITION OF "billing"."outbox" ` +
+		`FOR VALUES FROM \('2024-02-01T00:00:00Z'\) TO \('2024-02-02T00:00:00Z'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	p, err := storage.CreatePartition(context.Background(), day)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Name != "outbox_p20240201" {
+		t.Errorf("unexpected partition name: got %s", p.Name)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_ListPartitions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db, WithPartitioning())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	mock.ExpectQuery(`FROM pg_inherits`).
+		WithArgs(`"outbox"`).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("outbox_default").
+			AddRow("outbox_p20240131").
+			AddRow("outbox_p20240201"))
+
+	partitions, err := storage.ListPartitions(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Partition{
+		{
+			Name: "outbox_p20240131",
+			From: time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Name: "outbox_p20240201",
+			From: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	if !reflect.DeepEqual(partitions, expected) {
+		t.Errorf("unexpected partitions: got %+v, want %+v", partitions, expected)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLStorage_DropPartition(t *testing.T) {
+	tests := []struct {
+		name      string
+		mockSetup func(mock sqlmock.Sqlmock)
+		expected  bool
+	}{
+		{
+			name: "#1 Drops a delivered partition",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM "outbox_p20240131" WHERE status NOT IN \(\$1, \$2\)\)`).
+					WithArgs(RecordStatusSent, RecordStatusDead).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(`DROP TABLE "outbox_p20240131"`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			expected: true,
+		},
+		{
+			name: "#2 Keeps a partition with undelivered messages",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM "outbox_p20240131"`).
+					WithArgs(RecordStatusSent, RecordStatusDead).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewSQLStorage(db, WithPartitioning())
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).
+				WithArgs(storage.lockID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`SET LOCAL lock_timeout`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(`SELECT to_regclass\(\$1\) IS NOT NULL`).
+				WithArgs(`"outbox_p20240131"`).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			tt.mockSetup(mock)
+
+			dropped, err := storage.DropPartition(context.Background(),
+				storage.dailyPartition(time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if dropped != tt.expected {
+				t.Errorf("unexpected result: got %v, want %v", dropped, tt.expected)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_InsertMessage_PartitionedIgnoreDuplicates(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db, WithPartitioning())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	_, err = storage.InsertMessage(context.Background(), nil, StorageRecord{EventType: "UserCreated"}, IgnoreDuplicates())
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestSQLStorage_InsertMessages_NotifyChannel(t *testing.T) {
+	msgs := []StorageRecord{
+		{EventType: "UserCreated", AggregateType: "User", AggregateID: "1", Topic: "users"},
+		{EventType: "UserVerified", AggregateType: "User", AggregateID: "1", Topic: "users"},
+	}
+
+	tests := []struct {
+		name          string
+		msgs          []StorageRecord
+		opts          []InsertOption
+		rowsAffected  int64
+		notify        bool
+		expectedError error
+	}{
+		{
+			name:         "#1 Notifies once after all messages are inserted",
+			msgs:         msgs,
+			rowsAffected: 2,
+			notify:       true,
+		},
+		{
+			name: "#2 Does not notify without messages",
+		},
+		{
+			name:          "#3 Does not notify when all messages already exist",
+			msgs:          msgs,
+			opts:          []InsertOption{IgnoreDuplicates()},
+			expectedError: ErrMessageExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			storage, err := NewSQLStorage(db, WithNotifyChannel("outbox_events"))
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+
+			mock.ExpectBegin()
+			if len(tt.msgs) > 0 {
+				mock.ExpectExec(`INSERT INTO "outbox"`).WillReturnResult(sqlmock.NewResult(0, tt.rowsAffected))
+			}
+			if tt.notify {
+				mock.ExpectExec(`SELECT pg_notify\(\$1, ''\)`).
+					WithArgs("outbox_events").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("failed to begin transaction: %v", err)
+			}
+			if _, err = storage.InsertMessages(context.Background(), tx, tt.msgs, tt.opts...); !errors.Is(err, tt.expectedError) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.expectedError)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+// timeAround matches time arguments within a second of the given time.
+type timeAround time.Time
+
+func (a timeAround) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	d := t.Sub(time.Time(a))
+
+	return d > -time.Second && d < time.Second
+}
+
+// claimedRow is a record claimed by claimPendingQuery, together with its aggregate priority and seq.
+type claimedRow struct {
+	rec               *StorageRecord
+	aggregatePriority int
+	seq               int64
+}
+
+// claimedRecordRows returns mock rows returned by claimPendingQuery.
+func claimedRecordRows(claims ...claimedRow) *sqlmock.Rows {
+	rows := sqlmock.NewRows(append(strings.Split(strings.ReplaceAll(recordColumns, " ", ""), ","),
+		"claim_priority", "claim_seq"))
+	for _, claim := range claims {
+		rec := claim.rec
+		headers, _ := rec.Headers.Value()
+		rows.AddRow(rec.ID, rec.EventType, rec.AggregateType, rec.AggregateID, rec.Data, rec.CreatedAt, rec.Status,
+			rec.Attempts, rec.Topic, headers, rec.AvailableAt, rec.NextAttemptAt, rec.LastError, rec.LastErrorAt,
+			rec.SentAt, rec.Priority, rec.ContentEncoding, rec.KeyID, rec.PayloadRef, claim.aggregatePriority, claim.seq)
+	}
+
+	return rows
+}
+
+// recordRows returns mock rows selected as recordColumns.
+func recordRows(records ...*StorageRecord) *sqlmock.Rows {
+	rows := sqlmock.NewRows(strings.Split(strings.ReplaceAll(recordColumns, " ", ""), ","))
+	for _, rec := range records {
+		headers, _ := rec.Headers.Value()
+		rows.AddRow(
+			rec.ID,
+			rec.EventType,
+			rec.AggregateType,
+			rec.AggregateID,
+			rec.Data,
+			rec.CreatedAt,
+			rec.Status,
+			rec.Attempts,
+			rec.Topic,
+			headers,
+			rec.AvailableAt,
+			rec.NextAttemptAt,
+			rec.LastError,
+			rec.LastErrorAt,
+			rec.SentAt,
+			rec.Priority,
+			rec.ContentEncoding,
+			rec.KeyID,
+			rec.PayloadRef,
+		)
+	}
+
+	return rows
+}
+
+func TestNewSQLStorage_TableOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []SQLStorageOption
+		expectedTable string
+		expectedError bool
+	}{
+		{
+			name:          "#1 Defaults to the outbox table",
+			expectedTable: `"outbox"`,
+		},
+		{
+			name:          "#2 Schema qualified custom table",
+			opts:          []SQLStorageOption{WithSchema("billing"), WithTableName("events")},
+			expectedTable: `"billing"."events"`,
+		},
+		{
+			name:          "#3 Empty values keep the defaults",
+			opts:          []SQLStorageOption{WithSchema(""), WithTableName("")},
+			expectedTable: `"outbox"`,
+		},
+		{
+			name:          "#4 Rejects an invalid table name",
+			opts:          []SQLStorageOption{WithTableName(`outbox"; DROP TABLE users; --`)},
+			expectedError: true,
+		},
+		{
+			name:          "#5 Rejects an invalid schema name",
+			opts:          []SQLStorageOption{WithSchema("public.outbox")},
+			expectedError: true,
+		},
+		{
+			name:          "#6 Rejects an invalid notify channel name",
+			opts:          []SQLStorageOption{WithNotifyChannel("outbox; NOTIFY users")},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, err := NewSQLStorage(nil, tt.opts...)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("unexpected error: got %v, want error=%v", err, tt.expectedError)
+			}
+			if err != nil {
+				return
+			}
+
+			if table := storage.sql("{table}"); table != tt.expectedTable {
+				t.Errorf("unexpected table: got %s, want %s", table, tt.expectedTable)
+			}
+		})
+	}
+}